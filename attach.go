@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// attachTarget attaches the running process pid, its executable is found by /proc/<pid>/exe.
+func attachTarget(pid int) error {
+	var (
+		execfile string
+		err      error
+	)
+	if execfile, err = os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err != nil {
+		return err
+	}
+	// analyze before attaching, the process isn't disturbed if its executable can't be debugged
+	if target.bi, err = analyze(execfile); err != nil {
+		return err
+	}
+	if target.cmd, target.threads, err = attach(pid); err != nil {
+		return err
+	}
+	target.execFile = execfile
+	target.pages.clear()
+	target.signals.clearPending()
+	target.attached = true
+	target.tid = pid
+	target.starting = make(map[int]bool)
+	return nil
+}
+
+// readThreads returns the threads of the process pid which are listed in /proc/<pid>/task.
+func readThreads(pid int) ([]int, error) {
+	infos, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+	tids := make([]int, 0, len(infos))
+	for _, info := range infos {
+		if tid, err := strconv.Atoi(info.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
+
+// attach attaches every thread of the process pid, the threads are stopped after attaching,
+// and the threads which are created later are traced by PTRACE_O_TRACECLONE.
+func attach(pid int) (*exec.Cmd, []int, error) {
+	var (
+		process *os.Process
+		tids    []int
+		threads []int
+		err     error
+	)
+	if process, err = os.FindProcess(pid); err != nil {
+		return nil, nil, err
+	}
+	// !!! all ptrace requests have to be sent by the thread which attached
+	runtime.LockOSThread()
+	// the threads which are attached are released if the process can't be attached, so it keeps running
+	defer func() {
+		if err != nil {
+			for _, tid := range threads {
+				_ = syscall.PtraceDetach(tid)
+			}
+		}
+	}()
+
+	attached := make(map[int]bool)
+	// the threads may be created while attaching, so the tasks are read until no new thread is found
+	for found := true; found; {
+		found = false
+		if tids, err = readThreads(pid); err != nil {
+			return nil, nil, err
+		}
+		for _, tid := range tids {
+			if attached[tid] {
+				continue
+			}
+			found = true
+			if err = syscall.PtraceAttach(tid); err != nil {
+				if err == syscall.ESRCH {
+					// the thread has exited
+					err = nil
+					continue
+				}
+				return nil, nil, err
+			}
+			threads = append(threads, tid)
+			if err = waitAttachStop(tid); err != nil {
+				return nil, nil, err
+			}
+			if err = syscall.PtraceSetOptions(tid, syscall.PTRACE_O_TRACECLONE); err != nil {
+				return nil, nil, err
+			}
+			attached[tid] = true
+			logger.Debug("attach", zap.Int("pid", pid), zap.Int("tid", tid))
+		}
+	}
+	if !attached[pid] {
+		err = fmt.Errorf("can't attach process %d", pid)
+		return nil, nil, err
+	}
+
+	cmd := &exec.Cmd{Path: fmt.Sprintf("/proc/%d/exe", pid), Process: process}
+	return cmd, threads, nil
+}
+
+// waitAttachStop waits for the SIGSTOP of PTRACE_ATTACH, the other signals are passed to the thread.
+func waitAttachStop(tid int) error {
+	var s syscall.WaitStatus
+	for {
+		if _, err := syscall.Wait4(tid, &s, syscall.WALL, nil); err != nil {
+			return err
+		}
+		if s.Exited() || s.Signaled() {
+			return fmt.Errorf("thread %d has exited", tid)
+		}
+		if s.StopSignal() == syscall.SIGSTOP {
+			return nil
+		}
+		if err := syscall.PtraceCont(tid, int(s.StopSignal())); err != nil {
+			return err
+		}
+	}
+}
+
+// detach removes all breakpoints and releases the process, which keeps running without the debugger.
+func detach(bp *BP) error {
+	var (
+		regs syscall.PtraceRegs
+		err  error
+	)
+	tid := currentThread()
+	// the current thread may have just trapped on a breakpoint
+	if regs, err = currentProcess().Registers(tid); err != nil {
+		return err
+	}
+	if _, ok := bp.findBreakPoint(regs.PC() - 1); ok {
+		regs.SetPC(regs.PC() - 1)
+		if err = currentProcess().SetRegisters(tid, &regs); err != nil {
+			return err
+		}
+	}
+	for _, info := range bp.infos {
+		if err = bp.disableBreakPoint(tid, info); err != nil {
+			return err
+		}
+	}
+	bp.infos = nil
+
+	for _, thread := range currentProcess().Threads() {
+		// the signals which are passed are delivered, the process handles them without the debugger
+		if err = ptraceDetach(thread, target.signals.takePending(thread)); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	target.pages.clear()
+	target.signals.clearPending()
+	target.attached = false
+	target.threads = nil
+	target.tid = 0
+	target.starting = nil
+	target.frame = 0
+	return nil
+}
+
+// ptraceDetach is PtraceDetach with the signal which is delivered to the thread, like PtraceCont.
+func ptraceDetach(tid int, sig int) error {
+	if _, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_DETACH, uintptr(tid), 0, uintptr(sig), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
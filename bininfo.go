@@ -9,21 +9,31 @@ import (
 	"errors"
 	"fmt"
 	"go.uber.org/zap"
-	"golang.org/x/arch/x86/x86asm"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
-	"syscall"
 )
 
 type CompileUnit struct {
+	name      string
 	functions []*Function
+	ranges    [][2]uint64
+	optimized bool // compiled by go without `-N`
+
+	lowpc        uint64 // the base address of the location lists
+	addrBase     int64  // DW_AT_addr_base, the offset of the addresses of this unit in .debug_addr
+	loclistsBase int64  // DW_AT_loclists_base, the offset of the offsets table in .debug_loclists
+	dwarf5       bool   // the location lists are in .debug_loclists
+
+	prologueEnds []uint64 // the sorted addresses which are marked as the end of prologue in the line table
 }
 
 type Function struct {
 	name      string
-	lowpc     uint64
-	highpc    uint64
+	lowpc     uint64 // the entry of the function
+	highpc    uint64 // the end of the range which contains the entry
+	ranges    [][2]uint64
 	frameBase []byte
 	declFile  int64
 	external  bool
@@ -32,11 +42,71 @@ type Function struct {
 	cu        *CompileUnit
 }
 
+// InlinedCall is a copy of the function which is inlined into another function.
+type InlinedCall struct {
+	origin   *Function // the abstract function which is inlined
+	fn       *Function // the function which the code is inlined into
+	parent   *InlinedCall
+	depth    int
+	ranges   [][2]uint64
+	callFile string
+	callLine int
+
+	originOffset dwarf.Offset
+}
+
+func (call *InlinedCall) name() string {
+	if call.origin == nil {
+		return "?"
+	}
+	return call.origin.name
+}
+
+func (call *InlinedCall) contains(pc uint64) bool {
+	return rangesContain(call.ranges, pc)
+}
+
+func (cu *CompileUnit) contains(pc uint64) bool {
+	return rangesContain(cu.ranges, pc)
+}
+
+func (f *Function) contains(pc uint64) bool {
+	return rangesContain(f.ranges, pc)
+}
+
+// rangeIncludePc returns the range of f which contains pc, the code of the function may be non-contiguous.
+func (f *Function) rangeIncludePc(pc uint64) ([2]uint64, bool) {
+	for _, r := range f.ranges {
+		if r[0] <= pc && pc < r[1] {
+			return r, true
+		}
+	}
+	return [2]uint64{}, false
+}
+
+func rangesContain(ranges [][2]uint64, pc uint64) bool {
+	for _, r := range ranges {
+		if r[0] <= pc && pc < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
 type BI struct {
 	Sources           map[string]map[int][]*dwarf.LineEntry
 	Functions         []*Function
 	CompileUnits      []*CompileUnit
 	FramesInformation []*VirtualUnwindFrameInformation
+	InlinedCalls      []*InlinedCall
+	inlinedIndex      [][]inlinedRange // the ranges of InlinedCalls by depth, every depth is sorted by begin
+	FDEs              []fdeRange       // sorted by begin, the ranges don't overlap
+	DwarfData         *dwarf.Data
+
+	// the sections of the location lists, DWARF 4 uses .debug_loc and DWARF 5 uses .debug_loclists
+	LocSection      []byte
+	LoclistsSection []byte
+	AddrSection     []byte
 }
 
 func analyze(execfile string) (*BI, error) {
@@ -68,9 +138,15 @@ func analyze(execfile string) (*BI, error) {
 	if err = bi.ParseLineAndInfoSection(dwarfData); err != nil {
 		return nil, err
 	}
+	bi.DwarfData = dwarfData
+	if err = bi.openLocationSections(elffile); err != nil {
+		return nil, err
+	}
 	if err = bi.ParseFrameSection(elffile); err != nil {
 		return nil, err
 	}
+	bi.buildFDEIndex()
+	bi.buildInlinedIndex()
 
 	// debug source log
 	for file, mp := range bi.Sources {
@@ -139,12 +215,15 @@ func (bi *BI) ParseLineAndInfoSection(dwarfData *dwarf.Data) error {
 		curCompileUnit      *CompileUnit
 		curFunction         *Function
 		err                 error
-		ranges              [][2]uint64
 		lineReader          *dwarf.LineReader
 		lineEntry           *dwarf.LineEntry
 		curSubProgramEntry  *dwarf.Entry
 		curCompileUnitEntry *dwarf.Entry
 		dwarfReader         *dwarf.Reader
+		curFiles            []*dwarf.LineFile
+		tags                []dwarf.Tag    // the tags of the entries whose children are being read
+		inlinedCalls        []*InlinedCall // the inlined calls whose children are being read
+		functionsByOffset   = make(map[dwarf.Offset]*Function)
 	)
 	dwarfReader = dwarfData.Reader()
 	for {
@@ -154,9 +233,31 @@ func (bi *BI) ParseLineAndInfoSection(dwarfData *dwarf.Data) error {
 		if curEntry == nil {
 			break
 		}
+		if curEntry.Tag == 0 {
+			// the end of children
+			if len(tags) > 0 {
+				if tags[len(tags)-1] == dwarf.TagInlinedSubroutine {
+					inlinedCalls = inlinedCalls[:len(inlinedCalls)-1]
+				}
+				tags = tags[:len(tags)-1]
+			}
+			continue
+		}
+		if curEntry.Children {
+			tags = append(tags, curEntry.Tag)
+		}
 
 		if curEntry.Tag == dwarf.TagCompileUnit {
 			curCompileUnit = &CompileUnit{}
+			curCompileUnit.lowpc, _ = curEntry.Val(dwarf.AttrLowpc).(uint64)
+			curCompileUnit.addrBase, _ = curEntry.Val(dwarf.AttrAddrBase).(int64)
+			curCompileUnit.loclistsBase, _ = curEntry.Val(dwarf.AttrLoclistsBase).(int64)
+			curCompileUnit.name, _ = curEntry.Val(dwarf.AttrName).(string)
+			// like `Go cmd/compile go1.16; -N -l regabi`
+			if producer, ok := curEntry.Val(dwarf.AttrProducer).(string); ok && strings.HasPrefix(producer, "Go ") {
+				curCompileUnit.optimized = !strings.Contains(producer, " -N")
+			}
+			curCompileUnit.dwarf5 = curEntry.AttrField(dwarf.AttrAddrBase) != nil || curEntry.AttrField(dwarf.AttrLoclistsBase) != nil
 			bi.CompileUnits = append(bi.CompileUnits, curCompileUnit)
 
 			fields := curEntry.Field
@@ -170,24 +271,22 @@ func (bi *BI) ParseLineAndInfoSection(dwarfData *dwarf.Data) error {
 			}
 			logger.Debug("|================== END ============================|")
 
-			// LowPc(Attr) + Ranges(Attr) = HighPc, (* Data)Ranges return [LowPc, HightPc]
-			/*if ranges, err = dwarfData.Ranges(curEntry); err != nil {
-				return nil, err
-			}
-
-			if ranges != nil && len(ranges) >= 1{
-				lowPc := ranges[0][0]
-				hightPc := ranges[0][1]
+			// (* Data)Ranges handles DW_AT_ranges, and DW_AT_high_pc which is an address or an offset from DW_AT_low_pc
+			if curCompileUnit.ranges, err = dwarfData.Ranges(curEntry); err != nil {
+				return err
 			}
-			*/
-			_ = ranges
 
 			if lineReader, err = dwarfData.LineReader(curEntry); err != nil {
 				return err
 			}
+			curFiles = nil
 			lineEntry = &dwarf.LineEntry{}
 			cuname, _ := curEntry.Val(dwarf.AttrName).(string)
-			for {
+			// the unit may have no line table
+			if lineReader != nil {
+				curFiles = lineReader.Files()
+			}
+			for lineReader != nil {
 				if err = lineReader.Next(lineEntry); err != nil && err != io.EOF {
 					return err
 				}
@@ -204,13 +303,20 @@ func (bi *BI) ParseLineAndInfoSection(dwarfData *dwarf.Data) error {
 					*copyLineEntry = *lineEntry
 					bi.Sources[lineEntry.File.Name][lineEntry.Line] = append(bi.Sources[lineEntry.File.Name][lineEntry.Line], copyLineEntry)
 				}
+				if lineEntry.PrologueEnd {
+					curCompileUnit.prologueEnds = append(curCompileUnit.prologueEnds, lineEntry.Address)
+				}
 			}
+			sort.Slice(curCompileUnit.prologueEnds, func(i, j int) bool {
+				return curCompileUnit.prologueEnds[i] < curCompileUnit.prologueEnds[j]
+			})
 
 			curCompileUnitEntry = curEntry
 		}
 
 		if curEntry.Tag == dwarf.TagSubprogram {
 			curFunction = &Function{}
+			functionsByOffset[curEntry.Offset] = curFunction
 			curCompileUnit.functions = append(curCompileUnit.functions, curFunction)
 			curFunction.cu = curCompileUnit
 			bi.Functions = append(bi.Functions, curFunction)
@@ -223,14 +329,8 @@ func (bi *BI) ParseLineAndInfoSection(dwarfData *dwarf.Data) error {
 					if val, ok := field.Val.(string); ok {
 						curFunction.name = val
 					}
-				case dwarf.AttrLowpc:
-					if val, ok := field.Val.(uint64); ok {
-						curFunction.lowpc = val
-					}
-				case dwarf.AttrHighpc:
-					if val, ok := field.Val.(uint64); ok {
-						curFunction.highpc = val
-					}
+				case dwarf.AttrLowpc, dwarf.AttrHighpc, dwarf.AttrRanges:
+					// computed by (* Data)Ranges below
 				case dwarf.AttrFrameBase:
 					if val, ok := field.Val.([]byte); ok {
 						curFunction.frameBase = val
@@ -254,6 +354,21 @@ func (bi *BI) ParseLineAndInfoSection(dwarfData *dwarf.Data) error {
 			}
 			logger.Debug("|================== END ============================|")
 
+			if curFunction.ranges, err = dwarfData.Ranges(curEntry); err != nil {
+				return err
+			}
+			if len(curFunction.ranges) > 0 {
+				sort.Slice(curFunction.ranges, func(i, j int) bool {
+					return curFunction.ranges[i][0] < curFunction.ranges[j][0]
+				})
+				curFunction.lowpc, curFunction.highpc = curFunction.ranges[0][0], curFunction.ranges[0][1]
+				if entry, ok := curEntry.Val(dwarf.AttrEntrypc).(uint64); ok {
+					if r, ok := curFunction.rangeIncludePc(entry); ok {
+						curFunction.lowpc, curFunction.highpc = entry, r[1]
+					}
+				}
+			}
+
 			curSubProgramEntry = curEntry
 		}
 
@@ -264,7 +379,33 @@ func (bi *BI) ParseLineAndInfoSection(dwarfData *dwarf.Data) error {
 		curEntry.Tag == dwarf.TagConstType ||
 		curEntry.Tag == dwarf.TagPointerType ||
 		curEntry.Tag == dwarf.TagStringType */
-		if curEntry.Tag == dwarf.TagVariable {
+		if curEntry.Tag == dwarf.TagInlinedSubroutine {
+			call := &InlinedCall{fn: curFunction, depth: len(inlinedCalls) + 1}
+			if len(inlinedCalls) > 0 {
+				call.parent = inlinedCalls[len(inlinedCalls)-1]
+			}
+			if call.ranges, err = dwarfData.Ranges(curEntry); err != nil {
+				return err
+			}
+			call.originOffset, _ = curEntry.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+			if index, ok := curEntry.Val(dwarf.AttrCallFile).(int64); ok && index >= 0 && int(index) < len(curFiles) && curFiles[index] != nil {
+				call.callFile = curFiles[index].Name
+			}
+			if line, ok := curEntry.Val(dwarf.AttrCallLine).(int64); ok {
+				call.callLine = int(line)
+			}
+			bi.InlinedCalls = append(bi.InlinedCalls, call)
+			if curEntry.Children {
+				inlinedCalls = append(inlinedCalls, call)
+			}
+			logger.Debug("TagInlinedSubroutine",
+				zap.String("callFile", call.callFile),
+				zap.Int("callLine", call.callLine),
+				zap.Any("ranges", call.ranges))
+		}
+
+		// the variables of inlined calls have no name, they are described by the abstract origin
+		if (curEntry.Tag == dwarf.TagVariable || curEntry.Tag == dwarf.TagFormalParameter) && len(inlinedCalls) == 0 && curFunction != nil && inSubprogram(tags) {
 			curFunction.variables = append(curFunction.variables, curEntry)
 			logger.Debug("|================= START ===========================|")
 			fields := curEntry.Field
@@ -278,27 +419,121 @@ func (bi *BI) ParseLineAndInfoSection(dwarfData *dwarf.Data) error {
 		}
 	}
 
+	for _, call := range bi.InlinedCalls {
+		call.origin = functionsByOffset[call.originOffset]
+	}
+
 	_ = curSubProgramEntry
 	_ = curCompileUnitEntry
 	return nil
 }
 
-// not considered inline function
+// isOptimized reports whether the main package is compiled with optimizations.
+func (bi *BI) isOptimized() bool {
+	for _, cu := range bi.CompileUnits {
+		if cu.name == "main" && cu.optimized {
+			return true
+		}
+	}
+	return false
+}
+
+func inSubprogram(tags []dwarf.Tag) bool {
+	for _, tag := range tags {
+		if tag == dwarf.TagSubprogram {
+			return true
+		}
+	}
+	return false
+}
+
+// inlinedRange is a range of the inlined call, the ranges of the calls of one depth don't overlap.
+type inlinedRange struct {
+	begin, end uint64
+	call       *InlinedCall
+}
+
+// buildInlinedIndex indexes the ranges of the inlined calls by depth, so that they are found by binary search.
+func (bi *BI) buildInlinedIndex() {
+	bi.inlinedIndex = nil
+	for _, call := range bi.InlinedCalls {
+		for len(bi.inlinedIndex) < call.depth {
+			bi.inlinedIndex = append(bi.inlinedIndex, nil)
+		}
+		for _, r := range call.ranges {
+			bi.inlinedIndex[call.depth-1] = append(bi.inlinedIndex[call.depth-1], inlinedRange{begin: r[0], end: r[1], call: call})
+		}
+	}
+	for _, ranges := range bi.inlinedIndex {
+		sort.Slice(ranges, func(i, j int) bool {
+			return ranges[i].begin < ranges[j].begin
+		})
+	}
+}
+
+// inlinedCallsIncludePc returns the inlined calls which contain pc, the innermost one is the first.
+func (bi *BI) inlinedCallsIncludePc(pc uint64) []*InlinedCall {
+	var innermost *InlinedCall
+	// the deepest call which contains pc is the innermost one
+	for depth := len(bi.inlinedIndex); depth > 0 && innermost == nil; depth-- {
+		ranges := bi.inlinedIndex[depth-1]
+		i := sort.Search(len(ranges), func(i int) bool {
+			return ranges[i].begin > pc
+		})
+		if i > 0 && pc < ranges[i-1].end {
+			innermost = ranges[i-1].call
+		}
+	}
+	calls := make([]*InlinedCall, 0)
+	for call := innermost; call != nil; call = call.parent {
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// the inlined calls are found by inlinedCallsIncludePc
 func (bi *BI) findFunctionIncludePc(pc uint64) (*Function, error) {
-	for _, f := range bi.Functions {
-		if f.lowpc <= pc && pc < f.highpc {
-			return f, nil
+	for _, cu := range bi.CompileUnits {
+		// the units without ranges are checked by their functions
+		if len(cu.ranges) > 0 && !cu.contains(pc) {
+			continue
+		}
+		for _, f := range cu.functions {
+			if f.contains(pc) {
+				return f, nil
+			}
 		}
 	}
 	return nil, &NotFoundFuncErr{pc: pc}
 }
 
+// prologueEnd returns the first address after the prologue of f, which is marked by the line table of its unit.
+func (bi *BI) prologueEnd(f *Function) (uint64, bool) {
+	if f.cu == nil {
+		return 0, false
+	}
+	ranges := f.ranges
+	if len(ranges) == 0 {
+		ranges = [][2]uint64{{f.lowpc, f.highpc}}
+	}
+	ends := f.cu.prologueEnds
+	// the ranges are sorted, so the first address in them is the lowest
+	for _, r := range ranges {
+		i := sort.Search(len(ends), func(i int) bool { return ends[i] >= r[0] })
+		if i < len(ends) && ends[i] < r[1] {
+			return ends[i], true
+		}
+	}
+	return 0, false
+}
+
 func (bi *BI) ParseFrameSection(elffile *elf.File) error {
 	var (
 		err          error
 		frameSection *elf.Section
 		frameData    []byte
-		frameInfo    *VirtualUnwindFrameInformation
+		ehFrame      *ehSection
+		ehInfos      []*VirtualUnwindFrameInformation
 	)
 	frameSection = elffile.Section(".debug_frame")
 	if frameSection == nil {
@@ -326,20 +561,30 @@ func (bi *BI) ParseFrameSection(elffile *elf.File) error {
 			}
 			return b, nil
 		}
-		if frameData, err = sectionData(frameSection); err != nil {
-			return err
+		if frameSection != nil {
+			if frameData, err = sectionData(frameSection); err != nil {
+				return err
+			}
 		}
 	} else {
 		if frameData, err = frameSection.Data(); err != nil {
 			return err
 		}
 	}
-	if frameSection == nil {
-		return errors.New("can'tt find the .debug_frame or .zdebug_frame")
+
+	// .eh_frame covers the code of libc and cgo, and the binaries without .debug_frame
+	if ehFrame, err = openEHFrameSection(elffile); err != nil {
+		return err
+	}
+	if frameSection == nil && ehFrame == nil {
+		return errors.New("can'tt find the .debug_frame, .zdebug_frame or .eh_frame")
 	}
 
 	buffer := bytes.NewBuffer(frameData)
-	var curCIE *CommonInformationEntry
+	var (
+		curCIE    *CommonInformationEntry
+		frameInfo *VirtualUnwindFrameInformation
+	)
 	for {
 		if frameInfo, err = parseFrameInformation(buffer); err != nil {
 			if err == io.EOF {
@@ -358,28 +603,76 @@ func (bi *BI) ParseFrameSection(elffile *elf.File) error {
 			frameInfo.FDE.CIE = curCIE
 		}
 	}
-	return err
+	if err != nil || ehFrame == nil {
+		return err
+	}
+
+	if ehInfos, err = parseEHFrameSection(ehFrame); err != nil {
+		return err
+	}
+	bi.FramesInformation = append(bi.FramesInformation, ehInfos...)
+	return nil
 }
 
-func (bi *BI) findFrameInformation(pc uint64) (*Frame, error) {
-	var fde *FrameDescriptionEntry
-	for index, frameInfo := range bi.FramesInformation {
-		if frameInfo.FDE != nil {
-			if frameInfo.FDE.begin <= pc && pc <= (frameInfo.FDE.begin+frameInfo.FDE.size) {
-				//fde = frameInfo.FDE
-				//break
-				if fde == nil {
-					fde = frameInfo.FDE
-
-					logger.Debug("findFrameInfomation", zap.Int("index", index))
-				} else {
-					return nil, fmt.Errorf("dumplicate fde")
+// buildFDEIndex sorts the fdes by address for the binary search in findFDE.
+// When the ranges overlap, the former one is kept, so .debug_frame takes precedence over .eh_frame.
+func (bi *BI) buildFDEIndex() {
+	fdes := make([]*FrameDescriptionEntry, 0, len(bi.FramesInformation))
+	for _, frameInfo := range bi.FramesInformation {
+		if frameInfo != nil && frameInfo.FDE != nil && frameInfo.FDE.size != 0 {
+			fdes = append(fdes, frameInfo.FDE)
+		}
+	}
+	sort.SliceStable(fdes, func(i, j int) bool {
+		return fdes[i].begin < fdes[j].begin
+	})
+
+	// the fde which begins first is kept, so .debug_frame is preferred to .eh_frame on the equal begin,
+	// and the tail of a later fde which overlaps it is still covered by the later one
+	bi.FDEs = make([]fdeRange, 0, len(fdes))
+	for _, fde := range fdes {
+		begin := fde.begin
+		if n := len(bi.FDEs); n > 0 {
+			last := bi.FDEs[n-1]
+			if end := last.fde.begin + last.fde.size; end > begin {
+				logger.Debug("buildFDEIndex overlapped fde", zap.String("fde", fde.String()),
+					zap.String("kept", last.fde.String()))
+				if end >= fde.begin+fde.size {
+					continue
 				}
+				begin = end
 			}
 		}
+		bi.FDEs = append(bi.FDEs, fdeRange{begin: begin, fde: fde})
+	}
+}
+
+// fdeRange is the range of the index which is covered by fde, it begins after the begin of fde
+// when the head of fde is covered by another one.
+type fdeRange struct {
+	begin uint64
+	fde   *FrameDescriptionEntry
+}
+
+// findFDE returns the fde which covers pc, or nil.
+func (bi *BI) findFDE(pc uint64) *FrameDescriptionEntry {
+	i := sort.Search(len(bi.FDEs), func(i int) bool {
+		return bi.FDEs[i].begin > pc
+	})
+	if i == 0 {
+		return nil
 	}
+	if fde := bi.FDEs[i-1].fde; pc < fde.begin+fde.size {
+		return fde
+	}
+	return nil
+}
+
+// execFrameInstructions finds the fde which covers pc, and executes the instructions of its cie and itself until pc.
+func (bi *BI) execFrameInstructions(pc uint64) (*Frame, error) {
+	fde := bi.findFDE(pc)
 	if fde == nil {
-		return nil, fmt.Errorf("not find the frame cover pc = 0x%x", pc)
+		return nil, &NotFoundFrameErr{pc: pc}
 	}
 
 	cie := fde.CIE
@@ -394,6 +687,7 @@ func (bi *BI) findFrameInformation(pc uint64) (*Frame, error) {
 	}
 	frame.loc = fde.begin
 	frame.address = pc
+	frame.instructionsEnd = fde.instructionsEnd
 	logger.Debug("========================= cie end\n")
 
 	logger.Debug("========================= fde.instructions start \n")
@@ -401,26 +695,19 @@ func (bi *BI) findFrameInformation(pc uint64) (*Frame, error) {
 		return nil, err
 	}
 	logger.Debug("========================= fde.instructions end \n")
+	return frame, nil
+}
 
+// computeFrame computes the cfa of the frame at pc, regs are the DWARF numbered registers of this frame.
+func (bi *BI) computeFrame(pid int, pc uint64, regs []uint64) (*Frame, error) {
 	var (
-		regs syscall.PtraceRegs
-		err  error
+		frame *Frame
+		err   error
 	)
-	if regs, err = getRegisters(target.cmd); err != nil {
+	if frame, err = bi.execFrameInstructions(pc); err != nil {
 		return nil, err
 	}
-
-	frame.regs = make([]uint64, 17)
-	frame.regs[16] = regs.PC()
-	frame.regs[7] = regs.Rsp
-	frame.regs[6] = regs.Rbp
-
-	logger.Debug("findFrameInformation",
-		zap.Any("regs", regs),
-		zap.Uint64("16", frame.regs[16]),
-		zap.Uint64("07", frame.regs[7]),
-		zap.Uint64("06", frame.regs[6]),
-	)
+	frame.regs = regs
 
 	var framebase uint64
 	switch frame.cfa.rule {
@@ -434,20 +721,14 @@ func (bi *BI) findFrameInformation(pc uint64) (*Frame, error) {
 		reg := frame.regs[frame.cfa.reg]
 		framebase = reg + uint64(frame.cfa.offset)
 
-		logger.Debug("findFrameInformation",
+		logger.Debug("computeFrame",
 			zap.Uint64("frame.frambebase", frame.framebase),
 			zap.Int64("offset", frame.cfa.offset),
 			zap.Uint64("framebase", framebase))
-	/*case RuleOffset:
-	addr := frame.cfa.offset
-	buf := make([]byte, 8)
-	if _ ,err := syscall.PtracePeekData(cmd.Process.Pid, uintptr(addr), buf); err !=nil{
-		return nil, err
-	}
-	v := binary.LittleEndian.Uint64(buf)
-	frame.regs[7] = v
-	framebase = v*/
-
+	case RuleExpression:
+		if framebase, err = execDwarfExpression(pid, frame.cfa.expression, frame.regs, 0); err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("invalid cfa rule %v", frame.cfa.rule)
 	}
@@ -485,30 +766,56 @@ func (bi *BI) fileLineToPc(filename string, lineno int) (uint64, error) {
 	return bi.Sources[filename][lineno][0].Address, nil
 }
 
-func (bi *BI) fileLineToPcForBreakPoint(filename string, lineno int) (uint64, error) {
+// fileLineToPcsForBreakPoint returns an address for each copy of the line, every inlined call is a copy.
+// The address is the end of prologue, or the lowest address of the copy.
+func (bi *BI) fileLineToPcsForBreakPoint(filename string, lineno int) ([]uint64, error) {
 	if bi.Sources[filename] == nil || bi.Sources[filename][lineno] == nil || len(bi.Sources[filename][lineno]) == 0 {
-		return 0, NotFoundSourceLineErr
+		return nil, NotFoundSourceLineErr
 	}
-	lineEntryArray := bi.Sources[filename][lineno]
-	for _, v := range lineEntryArray {
-		if v.PrologueEnd {
-			return v.Address, nil
-		}
+	type lineCopy struct {
+		addr        uint64
+		prologueEnd bool
 	}
-	addr := uint64(0)
-	for i, v := range lineEntryArray {
-		if i == 0 {
-			addr = v.Address
-		} else {
-			if addr > v.Address {
-				addr = v.Address
-			}
+	var (
+		copies = make(map[interface{}]*lineCopy)
+		keys   = make([]interface{}, 0, 1)
+	)
+	for _, v := range bi.Sources[filename][lineno] {
+		if v.Address == 0 {
+			continue
+		}
+		// the copy is the innermost inlined call or the function which contains the address
+		var key interface{}
+		if calls := bi.inlinedCallsIncludePc(v.Address); len(calls) > 0 {
+			key = calls[0]
+		} else if f, err := bi.findFunctionIncludePc(v.Address); err == nil {
+			key = f
+		}
+
+		c, ok := copies[key]
+		if !ok {
+			copies[key] = &lineCopy{addr: v.Address, prologueEnd: v.PrologueEnd}
+			keys = append(keys, key)
+			continue
+		}
+		if c.prologueEnd {
+			continue
+		}
+		if v.PrologueEnd || v.Address < c.addr {
+			c.addr, c.prologueEnd = v.Address, v.PrologueEnd
 		}
 	}
-	if addr == 0 {
-		return 0, NotFoundSourceLineErr
+	if len(keys) == 0 {
+		return nil, NotFoundSourceLineErr
 	}
-	return addr, nil
+	pcs := make([]uint64, 0, len(keys))
+	for _, key := range keys {
+		pcs = append(pcs, copies[key].addr)
+	}
+	sort.Slice(pcs, func(i, j int) bool {
+		return pcs[i] < pcs[j]
+	})
+	return pcs, nil
 }
 
 func (bi *BI) getCurFileLineByPtracePc() (string, int, error) {
@@ -562,20 +869,3 @@ func (bi *BI) pcTofileLine(pc uint64) (string, int, error) {
 
 	return rangeMin.filename, rangeMin.lineno, nil
 }
-
-func (bi *BI) getSingleMemInst(pid int, pc uint64) (x86asm.Inst, error) {
-	var (
-		mem  []byte
-		err  error
-		inst x86asm.Inst
-	)
-
-	mem = make([]byte, 100)
-	if _, err = syscall.PtracePeekData(pid, uintptr(pc), mem); err != nil {
-		return x86asm.Inst{}, err
-	}
-	if inst, err = x86asm.Decode(mem, 64); err != nil {
-		return x86asm.Inst{}, err
-	}
-	return inst, nil
-}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"go.uber.org/zap"
+	"golang.org/x/arch/x86/x86asm"
 	"os"
 	"path"
 	"syscall"
@@ -52,12 +52,12 @@ func (bp *BP) setPcBreakPoint(pid int, pc uint64) ([]byte, error) {
 	}
 
 	original := make([]byte, 1)
-	_, err = syscall.PtracePeekData(pid, uintptr(pc), original)
+	_, err = currentProcess().ReadMemory(pid, pc, original)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = syscall.PtracePokeData(pid, uintptr(pc), []byte{0xCC})
+	_, err = currentProcess().WriteMemory(pid, pc, []byte{0xCC})
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +88,7 @@ func (bp *BP) SetFileLineBreakPoint(bi *BI, pid int, filename string, lineno int
 	}
 
 	fullfilename := path.Join(curDir, filename)
-	pc, err := bi.fileLineToPcForBreakPoint(fullfilename, lineno)
+	pcs, err := bi.fileLineToPcsForBreakPoint(fullfilename, lineno)
 	if err != nil {
 		logger.Error("SetFileLineBreakPoint:fileLineToPc",
 			zap.Error(err),
@@ -96,30 +96,66 @@ func (bp *BP) SetFileLineBreakPoint(bi *BI, pid int, filename string, lineno int
 		return nil, err
 	}
 	logger.Debug("SetFileLineBreakPoint:fileLineToPc",
-		zap.Uint64("pc", pc),
+		zap.Uint64s("pcs", pcs),
 		zap.String("fullfilename", fullfilename),
 		zap.Int("lineno", lineno))
 
+	// every inlined copy of the line has a breakpoint, the first one is returned
 	var (
 		info     *BInfo
 		original []byte
 	)
-	if original, err = bp.setPcBreakPoint(pid, pc); err != nil {
-		logger.Error("SetFileLineBreakPoint",
-			zap.Error(err),
-			zap.Int("Pid", pid),
-			zap.String("fullfilename", fullfilename),
-			zap.Int("lineno", lineno))
-		return nil, err
+	for _, pc := range pcs {
+		if original, err = bp.setPcBreakPoint(pid, pc); err != nil {
+			if err == HasExistedBreakPointErr {
+				continue
+			}
+			logger.Error("SetFileLineBreakPoint",
+				zap.Error(err),
+				zap.Int("Pid", pid),
+				zap.String("fullfilename", fullfilename),
+				zap.Int("lineno", lineno))
+			return nil, err
+		}
+		newInfo := &BInfo{original: original, filename: filename, lineno: lineno, pc: pc, kind: USERBPTYPE}
+		bp.infos = append(bp.infos, newInfo)
+		if info == nil {
+			info = newInfo
+		}
 	}
-	info = &BInfo{original: original, filename: filename, lineno: lineno, pc: pc, kind: USERBPTYPE}
-	bp.infos = append(bp.infos, info)
+	if info == nil {
+		return nil, HasExistedBreakPointErr
+	}
+	return info, nil
+}
 
-	return info, err
+// userBreakPoints returns the breakpoints of the user in order, the inlined copies of a line are one breakpoint.
+func (bp *BP) userBreakPoints() [][]*BInfo {
+	type location struct {
+		filename string
+		lineno   int
+	}
+	var (
+		groups = make([][]*BInfo, 0)
+		index  = make(map[location]int)
+	)
+	for _, v := range bp.infos {
+		if v.kind != USERBPTYPE {
+			continue
+		}
+		loc := location{filename: v.filename, lineno: v.lineno}
+		if i, ok := index[loc]; ok {
+			groups[i] = append(groups[i], v)
+			continue
+		}
+		index[loc] = len(groups)
+		groups = append(groups, []*BInfo{v})
+	}
+	return groups
 }
 
 func (bp *BP) Continue(pid int) error {
-	return syscall.PtraceCont(pid, 0)
+	return currentProcess().Continue(pid)
 }
 
 func (bp *BP) findBreakPoint(pc uint64) (*BInfo, bool) {
@@ -131,12 +167,43 @@ func (bp *BP) findBreakPoint(pc uint64) (*BInfo, bool) {
 	return nil, false
 }
 
+// restoreOriginal replaces the int3 of the breakpoints in mem, which is read from addr, by the original bytes.
+func (bp *BP) restoreOriginal(addr uint64, mem []byte) {
+	for _, info := range bp.infos {
+		if addr <= info.pc && info.pc < addr+uint64(len(mem)) {
+			copy(mem[info.pc-addr:], info.original)
+		}
+	}
+}
+
+// getSingleMemInst decodes the instruction at pc, the breakpoints in it are decoded as the original instruction.
+func (bp *BP) getSingleMemInst(pid int, pc uint64) (x86asm.Inst, error) {
+	var (
+		mem  []byte
+		n    int
+		err  error
+		inst x86asm.Inst
+	)
+
+	// an instruction of amd64 is 15 bytes at most, the instruction at the end of the text may be shorter
+	mem = make([]byte, 15)
+	if n, err = readMemory(pid, pc, mem); n == 0 {
+		return x86asm.Inst{}, err
+	}
+	mem = mem[:n]
+	bp.restoreOriginal(pc, mem)
+	if inst, err = x86asm.Decode(mem, 64); err != nil {
+		return x86asm.Inst{}, err
+	}
+	return inst, nil
+}
+
 func (bp *BP) enableBreakPoint(pid int, info *BInfo) error {
 	if info == nil {
 		return errors.New("enableBreakPoint breakpointinfo is null")
 	}
 	logger.Debug("enableBreakPoint", zap.Uint64("pc", info.pc))
-	if _, err := syscall.PtracePokeData(pid, uintptr(info.pc), []byte{0xCC}); err != nil {
+	if _, err := currentProcess().WriteMemory(pid, info.pc, []byte{0xCC}); err != nil {
 		return err
 	}
 	return nil
@@ -147,51 +214,35 @@ func (bp *BP) disableBreakPoint(pid int, info *BInfo) error {
 		return errors.New("disableBreakPoint breakpointinfo is null")
 	}
 	logger.Debug("disableBreakPoint", zap.Uint64("pc", info.pc))
-	if _, err := syscall.PtracePokeData(pid, uintptr(info.pc), info.original); err != nil {
+	if _, err := currentProcess().WriteMemory(pid, info.pc, info.original); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (bp *BP) singleStepInstructionWithBreakpointCheck(pid int) error {
+// singleStepInstructionWithBreakpointCheck steps over the breakpoint which the tracee has just trapped on
+// or is standing on, so that the tracee can be continued without hitting it again.
+func (bp *BP) singleStepInstructionWithBreakpointCheck(pid int) (StopReason, error) {
 	var (
-		pc   uint64
-		err  error
-		info *BInfo
-		ok   bool
+		pc  uint64
+		err error
+		ok  bool
+		s   syscall.WaitStatus
 	)
 
 	if pc, err = getPtracePc(); err != nil {
-		return err
+		return StopDone, err
 	}
-	pc = pc - 1
-	if info, ok = bp.findBreakPoint(pc); !ok {
-		return nil
-	}
-	if err = bp.disableBreakPoint(pid, info); err != nil {
-		return err
-	}
-	defer bp.enableBreakPoint(pid, info)
-
-	if err = setPcRegister(target.cmd, pc); err != nil {
-		return err
+	if _, ok = bp.findBreakPoint(pc - 1); !ok {
+		if _, ok = bp.findBreakPoint(pc); !ok {
+			return StopDone, nil
+		}
 	}
 
-	if err = syscall.PtraceSingleStep(pid); err != nil {
-		return err
+	if s, err = bp.singleStepInstruction(pid); err != nil {
+		return StopDone, err
 	}
-	var s syscall.WaitStatus
-	if _, err = syscall.Wait4(pid, &s, syscall.WALL, nil); err != nil {
-		return err
-	}
-	if s.Exited() {
-		return nil
-	}
-	if s.StopSignal() == syscall.SIGTRAP {
-		return nil
-	}
-
-	return fmt.Errorf("unknown waitstatus %v, signal %d", s, s.Signal())
+	return stopReasonOf(s)
 }
 
 func (bp *BP) clearInternalBreakPoint(pc uint64) {
@@ -38,6 +38,9 @@ const (
 	DW_CFA_val_offset_sf      = 0x15 // ULEB128, SLEB128
 	DW_CFA_val_expression     = 0x16 // ULEB128, BLOCK
 
+	DW_CFA_GNU_args_size                = 0x2e // ULEB128 size
+	DW_CFA_GNU_negative_offset_extended = 0x2f // ULEB128 register, ULEB128 offset
+
 	DW_CFA_lo_user = 0x1c
 	DW_CFA_hi_user = 0x3f
 
@@ -64,87 +67,308 @@ const (
 )
 
 type DWRule struct {
-	offset int64
-	reg    uint64
-	rule   Rule
+	offset     int64
+	reg        uint64
+	rule       Rule
+	expression []byte // for RuleExpression and RuleValExpression
+}
+
+// frameState is a row of the register-rule table, which is pushed by DW_CFA_remember_state.
+type frameState struct {
+	cfa      DWRule
+	regsRule map[uint64]DWRule
+}
+
+func copyRegsRule(regsRule map[uint64]DWRule) map[uint64]DWRule {
+	res := make(map[uint64]DWRule, len(regsRule))
+	for reg, rule := range regsRule {
+		res[reg] = rule
+	}
+	return res
+}
+
+// restoreRule sets the rule of reg to the one which is assigned by the initial instructions of cie.
+func (frame *Frame) restoreRule(reg uint64) {
+	if rule, ok := frame.initialRegsRule[reg]; ok {
+		frame.regsRule[reg] = rule
+		return
+	}
+	delete(frame.regsRule, reg)
+}
+
+func readBlock(buf *bytes.Buffer) ([]byte, error) {
+	size, _, err := DecodeULEB128(buf)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(buf.Len()) < size {
+		return nil, fmt.Errorf("block size %d is out of instructions", size)
+	}
+	return append([]byte(nil), buf.Next(int(size))...), nil
 }
 
 func execSingleInstruction(frame *Frame, buf *bytes.Buffer) error {
 	var (
-		byte byte
-		err  error
+		operand uint8
+		byte    byte
+		err     error
 	)
 
 	if byte, err = buf.ReadByte(); err != nil {
 		return err
 	}
-	switch byte & high_2_bits {
-	case DW_CFA_advance_loc:
-		byte = DW_CFA_advance_loc
-		if err = buf.UnreadByte(); err != nil {
-			return err
+	// the primary opcodes in the high 2 bits, and the operand in the low 6 bits
+	if byte&high_2_bits != 0 {
+		operand = byte & low_6_offset
+		byte &= high_2_bits
+	}
+
+	// the operands of the opcode may be truncated in corrupted instructions
+	uleb := func() (uint64, error) {
+		v, _, err := DecodeULEB128(buf)
+		if err != nil {
+			return 0, fmt.Errorf("DW_CFA opcode 0x%x is truncated", byte)
 		}
+		return v, nil
+	}
+	sleb := func() (int64, error) {
+		v, _, err := DecodeSLEB128(buf)
+		if err != nil {
+			return 0, fmt.Errorf("DW_CFA opcode 0x%x is truncated", byte)
+		}
+		return v, nil
+	}
+	// the register and the offset of the cfa can only be changed separately if it is defined by them
+	checkCFARule := func() error {
+		if frame.cfa.rule == RuleExpression {
+			return fmt.Errorf("DW_CFA opcode 0x%x changes the cfa which is defined by an expression", byte)
+		}
+		return nil
+	}
+
+	switch byte {
+	case DW_CFA_advance_loc:
+		frame.loc += uint64(operand) * frame.cie.code_alignment_factor
+		logger.Debug(fmt.Sprintf("DW_CFA_advance_loc, delta %d, frame.loc=%d\n", uint64(operand), frame.loc))
 	case DW_CFA_offset:
-		byte = DW_CFA_offset
-		if err = buf.UnreadByte(); err != nil {
+		reg := uint64(operand)
+		offset, err := uleb()
+		if err != nil {
 			return err
 		}
+		frame.regsRule[reg] = DWRule{offset: int64(offset) * frame.cie.data_alignment_factor, rule: RuleOffset}
+		logger.Debug(fmt.Sprintf("DW_CFA_offset, reg %d, offset %d, dwrule.offset %d\n", reg, offset, frame.regsRule[reg].offset))
 	case DW_CFA_restore:
-		byte = DW_CFA_restore
-		if err = buf.UnreadByte(); err != nil {
+		frame.restoreRule(uint64(operand))
+		logger.Debug(fmt.Sprintf("DW_CFA_restore, reg %d\n", operand))
+	case DW_CFA_set_loc:
+		// the address is encoded like the addresses of fde, the remaining instructions locate the operand
+		if frame.loc, err = readEncodedPointer(buf, frame.cie.ptrEncoding, frame.instructionsEnd-uint64(buf.Len()), 0); err != nil {
 			return err
 		}
-	}
-
-	switch byte {
+		logger.Debug(fmt.Sprintf("DW_CFA_set_loc, frame.loc=%d\n", frame.loc))
+	case DW_CFA_advance_loc1:
+		delta, err := buf.ReadByte()
+		if err != nil {
+			return err
+		}
+		frame.loc += uint64(delta) * frame.cie.code_alignment_factor
+		logger.Debug(fmt.Sprintf("DW_CFA_advance_loc1, delta %d, frame.loc=%d\n", uint64(delta), frame.loc))
+	case DW_CFA_advance_loc2:
+		var delta uint16
+		if err = binary.Read(buf, binary.LittleEndian, &delta); err != nil {
+			return err
+		}
+		frame.loc += uint64(delta) * frame.cie.code_alignment_factor
+		logger.Debug(fmt.Sprintf("DW_CFA_advance_loc2, delta %d, frame.loc=%d\n", uint64(delta), frame.loc))
+	case DW_CFA_advance_loc4:
+		var delta uint32
+		if err = binary.Read(buf, binary.LittleEndian, &delta); err != nil {
+			return err
+		}
+		frame.loc += uint64(delta) * frame.cie.code_alignment_factor
+		logger.Debug(fmt.Sprintf("DW_CFA_advance_loc4, delta %d, frame.loc=%d\n", uint64(delta), frame.loc))
 	case DW_CFA_offset_extended:
-		reg, _, _ := DecodeULEB128(buf)
-		offset, _, _ := DecodeULEB128(buf)
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		offset, err := uleb()
+		if err != nil {
+			return err
+		}
 		frame.regsRule[reg] = DWRule{offset: int64(offset) * frame.cie.data_alignment_factor, rule: RuleOffset}
 		logger.Debug(fmt.Sprintf("DW_CFA_offset_extended, reg %d, offset %d, dwrule.offset %d\n", reg, offset, frame.regsRule[reg].offset))
+	case DW_CFA_offset_extended_sf:
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		offset, err := sleb()
+		if err != nil {
+			return err
+		}
+		frame.regsRule[reg] = DWRule{offset: offset * frame.cie.data_alignment_factor, rule: RuleOffset}
+		logger.Debug(fmt.Sprintf("DW_CFA_offset_extended_sf, reg %d, offset %d, dwrule.offset %d\n", reg, offset, frame.regsRule[reg].offset))
+	case DW_CFA_restore_extended:
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		frame.restoreRule(reg)
+		logger.Debug(fmt.Sprintf("DW_CFA_restore_extended, reg %d\n", reg))
+	case DW_CFA_undefined:
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		frame.regsRule[reg] = DWRule{rule: RuleUndefined}
+		logger.Debug(fmt.Sprintf("DW_CFA_undefined, reg %d\n", reg))
+	case DW_CFA_same_value:
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		frame.regsRule[reg] = DWRule{rule: RuleSameVal}
+		logger.Debug(fmt.Sprintf("DW_CFA_same_value, reg %d\n", reg))
+	case DW_CFA_register:
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		reg2, err := uleb()
+		if err != nil {
+			return err
+		}
+		frame.regsRule[reg] = DWRule{reg: reg2, rule: RuleRegister}
+		logger.Debug(fmt.Sprintf("DW_CFA_register, reg %d, reg2 %d\n", reg, reg2))
+	case DW_CFA_remember_state:
+		frame.states = append(frame.states, frameState{cfa: *frame.cfa, regsRule: copyRegsRule(frame.regsRule)})
+		logger.Debug(fmt.Sprintf("DW_CFA_remember_state, depth %d\n", len(frame.states)))
+	case DW_CFA_restore_state:
+		if len(frame.states) == 0 {
+			return fmt.Errorf("DW_CFA_restore_state without DW_CFA_remember_state")
+		}
+		state := frame.states[len(frame.states)-1]
+		frame.states = frame.states[:len(frame.states)-1]
+		*frame.cfa = state.cfa
+		frame.regsRule = state.regsRule
+		logger.Debug(fmt.Sprintf("DW_CFA_restore_state, depth %d\n", len(frame.states)))
 	case DW_CFA_def_cfa:
-		frame.cfa.reg, _, _ = DecodeULEB128(buf)
-		offset, _, _ := DecodeULEB128(buf)
-		frame.cfa.offset = int64(offset)
-		frame.cfa.rule = RuleCFA
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		offset, err := uleb()
+		if err != nil {
+			return err
+		}
+		*frame.cfa = DWRule{reg: reg, offset: int64(offset), rule: RuleCFA}
 		logger.Debug(fmt.Sprintf("DW_CFA_def_cfa, reg %d, offset %d\n", frame.cfa.reg, frame.cfa.offset))
+	case DW_CFA_def_cfa_sf:
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		offset, err := sleb()
+		if err != nil {
+			return err
+		}
+		*frame.cfa = DWRule{reg: reg, offset: offset * frame.cie.data_alignment_factor, rule: RuleCFA}
+		logger.Debug(fmt.Sprintf("DW_CFA_def_cfa_sf, reg %d, offset %d\n", frame.cfa.reg, frame.cfa.offset))
 	case DW_CFA_def_cfa_register:
-		reg, _, _ := DecodeULEB128(buf)
+		if err = checkCFARule(); err != nil {
+			return err
+		}
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
 		frame.cfa.reg = reg
-		frame.cfa.rule = RuleUndefined
+		frame.cfa.rule = RuleCFA
 		logger.Debug(fmt.Sprintf("DW_CFA_def_cfa_register, cfa.reg %d, cfa.offset %d\n", reg, frame.cfa.offset))
+	case DW_CFA_def_cfa_offset:
+		if err = checkCFARule(); err != nil {
+			return err
+		}
+		offset, err := uleb()
+		if err != nil {
+			return err
+		}
+		frame.cfa.offset = int64(offset)
+		logger.Debug(fmt.Sprintf("DW_CFA_def_cfa_offset, offset %d\n", frame.cfa.offset))
 	case DW_CFA_def_cfa_offset_sf:
-		offset, _, _ := DecodeSLEB128(buf)
+		if err = checkCFARule(); err != nil {
+			return err
+		}
+		offset, err := sleb()
+		if err != nil {
+			return err
+		}
 		t := offset
 		offset *= frame.cie.data_alignment_factor
 		frame.cfa.offset = offset
 		logger.Debug(fmt.Sprintf("DW_CFA_def_cfa_offset_sf, offset *= frame.data_aligment_factor, %d = %d * %d\n",
 			offset, t, frame.cie.data_alignment_factor))
-	case DW_CFA_advance_loc:
-		if byte, err = buf.ReadByte(); err != nil {
+	case DW_CFA_def_cfa_expression:
+		expression, err := readBlock(buf)
+		if err != nil {
 			return err
 		}
-		delta := byte & low_6_offset
-		frame.loc += uint64(delta) * frame.cie.code_alignment_factor
-		logger.Debug(fmt.Sprintf("DW_CFA_advance_loc, delta %d, frame.loc=%d\n", uint64(delta), frame.loc))
-	case DW_CFA_advance_loc1:
-		delta, err := buf.ReadByte()
+		*frame.cfa = DWRule{rule: RuleExpression, expression: expression}
+		logger.Debug(fmt.Sprintf("DW_CFA_def_cfa_expression, expression %v\n", expression))
+	case DW_CFA_expression, DW_CFA_val_expression:
+		reg, err := uleb()
 		if err != nil {
 			return err
 		}
-		frame.loc += uint64(delta) * frame.cie.code_alignment_factor
-		logger.Debug(fmt.Sprintf("DW_CFA_advance_loc1, delta %d, frame.loc=%d\n", uint64(delta), frame.loc))
-	case DW_CFA_advance_loc2:
-		var delta uint16
-		binary.Read(buf, binary.LittleEndian, &delta)
-		frame.loc += uint64(delta) * frame.cie.code_alignment_factor
-		logger.Debug(fmt.Sprintf("DW_CFA_advance_loc2, delta %d, frame.loc=%d\n", uint64(delta), frame.loc))
-	case DW_CFA_restore:
-		if byte, err = buf.ReadByte(); err != nil {
+		expression, err := readBlock(buf)
+		if err != nil {
 			return err
 		}
-		reg := uint64(byte & low_6_offset)
-		frame.regsRule[reg] = DWRule{rule: RuleUndefined}
+		rule := RuleExpression
+		if byte == DW_CFA_val_expression {
+			rule = RuleValExpression
+		}
+		frame.regsRule[reg] = DWRule{rule: rule, expression: expression}
+		logger.Debug(fmt.Sprintf("DW_CFA_expression, reg %d, expression %v\n", reg, expression))
+	case DW_CFA_val_offset:
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		offset, err := uleb()
+		if err != nil {
+			return err
+		}
+		frame.regsRule[reg] = DWRule{offset: int64(offset) * frame.cie.data_alignment_factor, rule: RuleValOffset}
+		logger.Debug(fmt.Sprintf("DW_CFA_val_offset, reg %d, dwrule.offset %d\n", reg, frame.regsRule[reg].offset))
+	case DW_CFA_val_offset_sf:
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		offset, err := sleb()
+		if err != nil {
+			return err
+		}
+		frame.regsRule[reg] = DWRule{offset: offset * frame.cie.data_alignment_factor, rule: RuleValOffset}
+		logger.Debug(fmt.Sprintf("DW_CFA_val_offset_sf, reg %d, dwrule.offset %d\n", reg, frame.regsRule[reg].offset))
+	case DW_CFA_GNU_args_size:
+		// the size of arguments pushed on the stack, it doesn't change any rule
+		if _, err = uleb(); err != nil {
+			return err
+		}
+	case DW_CFA_GNU_negative_offset_extended:
+		reg, err := uleb()
+		if err != nil {
+			return err
+		}
+		offset, err := uleb()
+		if err != nil {
+			return err
+		}
+		frame.regsRule[reg] = DWRule{offset: -int64(offset) * frame.cie.data_alignment_factor, rule: RuleOffset}
 	case DW_CFA_nop:
 		return nil
 	default:
@@ -160,6 +384,8 @@ func execCIEInstructions(frame *Frame, buf *bytes.Buffer) error {
 			return err
 		}
 	}
+	// DW_CFA_restore goes back to the rules of the initial instructions
+	frame.initialRegsRule = copyRegsRule(frame.regsRule)
 	return nil
 }
 
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// the offsets in struct elf_prstatus of amd64, which is the descriptor of NT_PRSTATUS
+const (
+	prstatusCursigOffset = 12
+	prstatusPidOffset    = 32
+	prstatusRegsOffset   = 112
+)
+
+// the note of the auxiliary vector, which isn't defined by debug/elf
+const ntAuxv = 6
+
+// coreThread is a thread of the process when the core is dumped.
+type coreThread struct {
+	tid    int
+	regs   syscall.PtraceRegs
+	fpregs []byte // NT_FPREGSET, which follows NT_PRSTATUS of the thread
+}
+
+// coreSegment is a PT_LOAD segment of the core, the memory after filesz is zero.
+type coreSegment struct {
+	vaddr  uint64
+	filesz uint64
+	memsz  uint64
+	reader io.ReaderAt
+}
+
+// Core is the process which is read from an ELF core file, it is read-only.
+type Core struct {
+	pid      int
+	signal   syscall.Signal // the signal which dumps the core
+	threads  []*coreThread  // the thread which receives the signal is the first
+	auxv     []byte
+	segments []*coreSegment
+	text     []*coreSegment // the segments of the executable, the text isn't dumped into the core by default
+	files    []*elf.File
+}
+
+// openCore reads the threads and the memory of the core file, execfile is the executable of the process.
+func openCore(execfile string, corefile string) (*Core, error) {
+	var (
+		corefd *elf.File
+		execfd *elf.File
+		err    error
+	)
+	if corefd, err = elf.Open(corefile); err != nil {
+		return nil, err
+	}
+	core := &Core{files: []*elf.File{corefd}}
+	if corefd.Type != elf.ET_CORE {
+		core.Close()
+		return nil, fmt.Errorf("%s isn't a core file", corefile)
+	}
+	if corefd.Machine != elf.EM_X86_64 {
+		core.Close()
+		return nil, fmt.Errorf("not support the core of %s", corefd.Machine)
+	}
+	for _, prog := range corefd.Progs {
+		switch prog.Type {
+		case elf.PT_NOTE:
+			if err = core.readNotes(prog.Open()); err != nil {
+				core.Close()
+				return nil, err
+			}
+		case elf.PT_LOAD:
+			core.segments = append(core.segments, &coreSegment{
+				vaddr: prog.Vaddr, filesz: prog.Filesz, memsz: prog.Memsz, reader: prog.ReaderAt})
+		}
+	}
+	if len(core.threads) == 0 {
+		core.Close()
+		return nil, fmt.Errorf("%s has no NT_PRSTATUS", corefile)
+	}
+
+	if execfd, err = elf.Open(execfile); err != nil {
+		core.Close()
+		return nil, err
+	}
+	core.files = append(core.files, execfd)
+	if entry := entryPointFromAuxvAMD64(core.auxv); entry != 0 && entry != execfd.Entry {
+		core.Close()
+		return nil, fmt.Errorf("%s isn't dumped by %s, the entry is 0x%x instead of 0x%x", corefile, execfile, entry, execfd.Entry)
+	}
+	for _, prog := range execfd.Progs {
+		if prog.Type == elf.PT_LOAD {
+			core.text = append(core.text, &coreSegment{
+				vaddr: prog.Vaddr, filesz: prog.Filesz, memsz: prog.Memsz, reader: prog.ReaderAt})
+		}
+	}
+	logger.Debug("openCore", zap.Int("pid", core.pid), zap.Int("threads", len(core.threads)),
+		zap.Int("segments", len(core.segments)))
+	return core, nil
+}
+
+// readNotes reads NT_PRSTATUS and NT_FPREGSET of every thread and NT_AUXV in PT_NOTE.
+func (core *Core) readNotes(r io.Reader) error {
+	var (
+		data []byte
+		err  error
+	)
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return err
+	}
+	buf := bytes.NewBuffer(data)
+	for buf.Len() >= 12 {
+		namesz := binary.LittleEndian.Uint32(buf.Next(4))
+		descsz := binary.LittleEndian.Uint32(buf.Next(4))
+		kind := elf.NType(binary.LittleEndian.Uint32(buf.Next(4)))
+		// the name and the descriptor are aligned to 4 bytes
+		buf.Next(int((namesz + 3) &^ 3))
+		if buf.Len() < int(descsz) {
+			return io.ErrUnexpectedEOF
+		}
+		desc := buf.Next(int(descsz))
+		buf.Next(int((descsz+3)&^3 - descsz))
+
+		switch kind {
+		case elf.NT_PRSTATUS:
+			thread := &coreThread{}
+			if len(desc) < prstatusRegsOffset+binary.Size(thread.regs) {
+				return fmt.Errorf("invalid NT_PRSTATUS size %d", len(desc))
+			}
+			thread.tid = int(binary.LittleEndian.Uint32(desc[prstatusPidOffset:]))
+			if err = binary.Read(bytes.NewReader(desc[prstatusRegsOffset:]), binary.LittleEndian, &thread.regs); err != nil {
+				return err
+			}
+			if len(core.threads) == 0 {
+				core.pid = thread.tid
+				core.signal = syscall.Signal(binary.LittleEndian.Uint16(desc[prstatusCursigOffset:]))
+			}
+			core.threads = append(core.threads, thread)
+		case elf.NT_FPREGSET:
+			if len(core.threads) > 0 && len(desc) == fpregsSize {
+				core.threads[len(core.threads)-1].fpregs = desc
+			}
+		case ntAuxv:
+			core.auxv = desc
+		}
+	}
+	return nil
+}
+
+func (core *Core) Close() {
+	for _, f := range core.files {
+		f.Close()
+	}
+}
+
+func (core *Core) thread(tid int) (*coreThread, bool) {
+	for _, thread := range core.threads {
+		if thread.tid == tid {
+			return thread, true
+		}
+	}
+	return nil, false
+}
+
+func (core *Core) Pid() int {
+	return core.pid
+}
+
+func (core *Core) Threads() []int {
+	tids := make([]int, 0, len(core.threads))
+	for _, thread := range core.threads {
+		tids = append(tids, thread.tid)
+	}
+	return tids
+}
+
+// ReadMemory reads the memory at addr from the segments of the core, or the executable if not dumped.
+func (core *Core) ReadMemory(tid int, addr uint64, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		// the kernel dumps only the first page of the mappings of files, the rest is read from the executable
+		m, ok := readSegments(core.segments, addr+uint64(n), buf[n:], false)
+		if !ok {
+			if m, ok = readSegments(core.text, addr+uint64(n), buf[n:], true); !ok {
+				if m, ok = readSegments(core.segments, addr+uint64(n), buf[n:], true); !ok {
+					break
+				}
+			}
+		}
+		n += m
+	}
+	// the memory which isn't dumped can't be read, even partly
+	if n < len(buf) {
+		return n, fmt.Errorf("can't read the memory at 0x%x in the core", addr+uint64(n))
+	}
+	return n, nil
+}
+
+func (core *Core) Registers(tid int) (syscall.PtraceRegs, error) {
+	thread, ok := core.thread(tid)
+	if !ok {
+		return syscall.PtraceRegs{}, fmt.Errorf("can't find thread %d in the core", tid)
+	}
+	return thread.regs, nil
+}
+
+func (core *Core) FPRegisters(tid int) ([]byte, error) {
+	thread, ok := core.thread(tid)
+	if !ok {
+		return nil, fmt.Errorf("can't find thread %d in the core", tid)
+	}
+	if thread.fpregs == nil {
+		return nil, fmt.Errorf("thread %d has no NT_FPREGSET in the core", tid)
+	}
+	return thread.fpregs, nil
+}
+
+// the core is read-only, it can't be changed or executed
+func (core *Core) WriteMemory(tid int, addr uint64, data []byte) (int, error) {
+	return 0, CoreReadOnlyErr
+}
+
+func (core *Core) SetRegisters(tid int, regs *syscall.PtraceRegs) error {
+	return CoreReadOnlyErr
+}
+
+func (core *Core) SetFPRegisters(tid int, fpregs []byte) error {
+	return CoreReadOnlyErr
+}
+
+func (core *Core) Continue(tid int) error {
+	return CoreReadOnlyErr
+}
+
+func (core *Core) SingleStep(tid int) error {
+	return CoreReadOnlyErr
+}
+
+func (core *Core) Wait(tid int) (syscall.WaitStatus, error) {
+	return 0, CoreReadOnlyErr
+}
+
+// readSegments reads the memory at addr from the segment which covers it, the segment which isn't dumped
+// has no file data, it is skipped, so the executable is read. The memory after filesz is zero if zero,
+// or it isn't read.
+func readSegments(segments []*coreSegment, addr uint64, buf []byte, zero bool) (int, bool) {
+	for _, seg := range segments {
+		if seg.filesz == 0 || addr < seg.vaddr || addr >= seg.vaddr+seg.memsz {
+			continue
+		}
+		size := uint64(len(buf))
+		if end := seg.vaddr + seg.memsz; addr+size > end {
+			size = end - addr
+		}
+		offset := addr - seg.vaddr
+		if offset >= seg.filesz {
+			if !zero {
+				return 0, false
+			}
+			// the memory which is zero initialized
+			for i := range buf[:size] {
+				buf[i] = 0
+			}
+			return int(size), true
+		}
+		if offset+size > seg.filesz {
+			size = seg.filesz - offset
+		}
+		n, err := seg.reader.ReadAt(buf[:size], int64(offset))
+		if n == 0 && err != nil {
+			return 0, false
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+// coreCommands are the commands which only read the process, they work for the core.
+var coreCommands = map[string]bool{
+	"q": true, "quit": true, "h": true, "help": true, "bl": true, "bt": true, "backtrace": true,
+	"f": true, "frame": true, "up": true, "down": true, "l": true, "list": true, "locals": true, "args": true,
+	"p": true, "print": true, "disass": true, "disassemble": true, "goroutines": true, "x": true,
+}
+
+func isCoreCommand(input string) bool {
+	return coreCommands[strings.Split(input, " ")[0]]
+}
+
+// coreTarget opens the core file of execfile, the process of the core is inspected like a stopped process.
+func coreTarget(execfile string, corefile string) error {
+	var err error
+	if target.bi, err = analyze(execfile); err != nil {
+		return err
+	}
+	if target.core, err = openCore(execfile, corefile); err != nil {
+		return err
+	}
+	target.process = target.core
+	target.execFile = execfile
+	target.tid = target.core.threads[0].tid
+	// the process doesn't exist, the pid is only displayed, it must never be signaled or traced
+	target.cmd = &exec.Cmd{Path: execfile, Process: &os.Process{Pid: target.core.pid}}
+	return nil
+}
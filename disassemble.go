@@ -7,7 +7,6 @@ import (
 	"os"
 	"path"
 	"strings"
-	"syscall"
 )
 
 func disassemble(pid int, bp *BP, lowpc uint64, highpc uint64) (map[uint64]bool, [][]byte, []uint64, []x86asm.Inst, error) {
@@ -24,7 +23,7 @@ func disassemble(pid int, bp *BP, lowpc uint64, highpc uint64) (map[uint64]bool,
 		pcMap   map[uint64]bool
 		curMem  []byte
 	)
-	if n, err = syscall.PtracePeekData(pid, uintptr(lowpc), mem); err != nil {
+	if n, err = readMemory(pid, lowpc, mem); err != nil {
 		return nil, nil, nil, nil, err
 	}
 	mem = mem[:n]
@@ -82,9 +81,9 @@ func tryCuttingFilename(filename string) string {
 	return filename
 }
 
-func listDisassembleByPtracePc(bi *BI, bp *BP, pid int) error {
+// listDisassembleByPc shows the asm of the function which contains pc, and marks the instruction of pc.
+func listDisassembleByPc(bi *BI, bp *BP, pid int, pc uint64) error {
 	var (
-		pc       uint64
 		pcs      []uint64
 		amsInsts []x86asm.Inst
 		err      error
@@ -95,13 +94,11 @@ func listDisassembleByPtracePc(bi *BI, bp *BP, pid int) error {
 		pcBpMap  map[uint64]bool
 	)
 
-	if pc, err = getPtracePc(); err != nil {
-		return err
-	}
 	if f, err = bi.findFunctionIncludePc(pc); err != nil {
 		return err
 	}
-	if pcBpMap, mems, pcs, amsInsts, err = disassemble(pid, bp, f.lowpc, f.highpc); err != nil {
+	r, _ := f.rangeIncludePc(pc)
+	if pcBpMap, mems, pcs, amsInsts, err = disassemble(pid, bp, r[0], r[1]); err != nil {
 		return err
 	}
 	out := make([]string, 0, len(amsInsts))
@@ -128,3 +125,38 @@ func listDisassembleByPtracePc(bi *BI, bp *BP, pid int) error {
 	fmt.Fprintln(stdout, strings.Join(out, ""))
 	return nil
 }
+
+// listInstructionByPtracePc show the instruction which will be executed next.
+func listInstructionByPtracePc(bi *BI, bp *BP, pid int) error {
+	var (
+		pc       uint64
+		err      error
+		inst     x86asm.Inst
+		filename string
+		lineno   int
+		ok       bool
+	)
+
+	if pc, err = getPtracePc(); err != nil {
+		return err
+	}
+	if _, ok = bp.findBreakPoint(pc - 1); ok {
+		pc = pc - 1
+	}
+	if inst, err = bp.getSingleMemInst(pid, pc); err != nil {
+		return err
+	}
+	if filename, lineno, err = bi.pcTofileLine(pc); err != nil {
+		return err
+	}
+
+	mem := make([]byte, inst.Len)
+	if _, err = readMemory(pid, pc, mem); err != nil {
+		return err
+	}
+	bp.restoreOriginal(pc, mem)
+
+	fmt.Fprintf(stdout, "current process pc = 0x%x\n", pc)
+	fmt.Fprintf(stdout, "===> %s:%-7d 0x%-7x %-20x %s\n", path.Base(filename), lineno, pc, mem, inst.String())
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// the size of struct elf_prstatus of amd64
+const prstatusSize = 336
+
+// mapping is a readable mapping in /proc/<pid>/maps.
+type mapping struct {
+	start  uint64
+	end    uint64
+	flags  elf.ProgFlag
+	dumped bool // false if it can't be read, like [vvar], it is written with Filesz 0
+}
+
+// readMappings reads the readable mappings of the process, the ones which can't be read from mem,
+// which is /proc/<pid>/mem, aren't dumped. Their memory is copied into the core by writeCore.
+func readMappings(pid int, mem io.ReaderAt) ([]*mapping, error) {
+	var (
+		maps *os.File
+		err  error
+	)
+	if maps, err = os.Open(fmt.Sprintf("/proc/%d/maps", pid)); err != nil {
+		return nil, err
+	}
+	defer maps.Close()
+
+	mappings := make([]*mapping, 0, 32)
+	scanner := bufio.NewScanner(maps)
+	for scanner.Scan() {
+		// 00400000-0048f000 r-xp 00000000 08:01 1234 /path/to/binary
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[1][0] != 'r' {
+			continue
+		}
+		if len(fields) >= 6 && fields[5] == "[vsyscall]" {
+			// the page of the kernel, which isn't in the address space of the process
+			continue
+		}
+		addrs := strings.SplitN(fields[0], "-", 2)
+		m := &mapping{flags: elf.PF_R}
+		if m.start, err = strconv.ParseUint(addrs[0], 16, 64); err != nil {
+			return nil, err
+		}
+		if m.end, err = strconv.ParseUint(addrs[1], 16, 64); err != nil {
+			return nil, err
+		}
+		if fields[1][1] == 'w' {
+			m.flags |= elf.PF_W
+		}
+		if fields[1][2] == 'x' {
+			m.flags |= elf.PF_X
+		}
+		if _, err = mem.ReadAt(make([]byte, 1), int64(m.start)); err == nil {
+			m.dumped = true
+		} else {
+			logger.Debug("readMappings", zap.Error(err), zap.String("mapping", scanner.Text()))
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, scanner.Err()
+}
+
+// originalReader reads the memory from addr, the breakpoints in it are read as their original bytes.
+type originalReader struct {
+	bp   *BP
+	r    io.Reader
+	addr uint64
+}
+
+func (r *originalReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.bp.restoreOriginal(r.addr, p[:n])
+	r.addr += uint64(n)
+	return n, err
+}
+
+// prstatus returns the descriptor of NT_PRSTATUS of the thread tid, which is stopped by sig.
+func prstatus(tid int, sig syscall.Signal, regs *syscall.PtraceRegs) []byte {
+	desc := make([]byte, prstatusSize)
+	binary.LittleEndian.PutUint32(desc[0:], uint32(sig))
+	binary.LittleEndian.PutUint16(desc[prstatusCursigOffset:], uint16(sig))
+	binary.LittleEndian.PutUint32(desc[prstatusPidOffset:], uint32(tid))
+	buf := bytes.NewBuffer(desc[prstatusRegsOffset:prstatusRegsOffset])
+	binary.Write(buf, binary.LittleEndian, regs)
+	return desc
+}
+
+// appendNote appends a note of "CORE", the name and the descriptor are aligned to 4 bytes.
+func appendNote(notes []byte, kind elf.NType, desc []byte) []byte {
+	name := []byte("CORE\x00\x00\x00\x00")
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header[0:], 5)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(desc)))
+	binary.LittleEndian.PutUint32(header[8:], uint32(kind))
+	notes = append(notes, header...)
+	notes = append(notes, name...)
+	notes = append(notes, desc...)
+	return append(notes, make([]byte, (len(desc)+3)&^3-len(desc))...)
+}
+
+// writeCore writes the ELF core of the stopped process pid into filename, which is read by `godbg core`.
+// The registers of the traced threads are the NT_PRSTATUS and NT_FPREGSET notes, the current thread is the first,
+// and the readable mappings are the PT_LOAD segments.
+func writeCore(bp *BP, pid int, filename string) error {
+	var (
+		regs     syscall.PtraceRegs
+		fpregs   []byte
+		mappings []*mapping
+		mem      *os.File
+		auxv     []byte
+		notes    []byte
+		err      error
+	)
+	threads := []int{currentThread()}
+	for _, tid := range currentProcess().Threads() {
+		if tid != currentThread() {
+			threads = append(threads, tid)
+		}
+	}
+	for i, tid := range threads {
+		if regs, err = currentProcess().Registers(tid); err != nil {
+			return err
+		}
+		// the breakpoints are removed from the core, so the thread which trapped is before the breakpoint
+		if _, ok := bp.findBreakPoint(regs.PC() - 1); ok {
+			regs.SetPC(regs.PC() - 1)
+		}
+		sig := syscall.SIGSTOP
+		if i == 0 {
+			sig = syscall.SIGTRAP
+		}
+		notes = appendNote(notes, elf.NT_PRSTATUS, prstatus(tid, sig, &regs))
+		if fpregs, err = currentProcess().FPRegisters(tid); err != nil {
+			return err
+		}
+		notes = appendNote(notes, elf.NT_FPREGSET, fpregs)
+	}
+	if auxv, err = ioutil.ReadFile(fmt.Sprintf("/proc/%d/auxv", pid)); err != nil {
+		return err
+	}
+	notes = appendNote(notes, ntAuxv, auxv)
+	if mem, err = os.Open(fmt.Sprintf("/proc/%d/mem", pid)); err != nil {
+		return err
+	}
+	defer mem.Close()
+	if mappings, err = readMappings(pid, mem); err != nil {
+		return err
+	}
+
+	// the header, the program headers, the notes, then the segments
+	headerSize := uint64(binary.Size(elf.Header64{}))
+	progSize := uint64(binary.Size(elf.Prog64{}))
+	header := elf.Header64{
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     headerSize,
+		Ehsize:    uint16(headerSize),
+		Phentsize: uint16(progSize),
+		Phnum:     uint16(len(mappings) + 1),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	header.Ident[elf.EI_OSABI] = byte(elf.ELFOSABI_NONE)
+
+	offset := headerSize + progSize*uint64(header.Phnum)
+	progs := []elf.Prog64{{Type: uint32(elf.PT_NOTE), Off: offset, Filesz: uint64(len(notes)), Align: 4}}
+	offset += uint64(len(notes))
+	for _, m := range mappings {
+		prog := elf.Prog64{Type: uint32(elf.PT_LOAD), Flags: uint32(m.flags), Off: offset, Vaddr: m.start,
+			Memsz: m.end - m.start, Align: 1}
+		if m.dumped {
+			prog.Filesz = prog.Memsz
+		}
+		offset += prog.Filesz
+		progs = append(progs, prog)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if err = binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if err = binary.Write(w, binary.LittleEndian, progs); err != nil {
+		return err
+	}
+	if _, err = w.Write(notes); err != nil {
+		return err
+	}
+	// the mappings are copied one by one, so the memory of the process isn't held at once
+	for _, m := range mappings {
+		if !m.dumped {
+			continue
+		}
+		var n int64
+		size := int64(m.end - m.start)
+		r := &originalReader{bp: bp, r: io.NewSectionReader(mem, int64(m.start), size), addr: m.start}
+		// the offsets of the segments are written already, so the mapping has to be copied completely
+		if n, err = io.Copy(w, r); err == nil && n != size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("can't dump the mapping 0x%x-0x%x: %v", m.start, m.end, err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	logger.Debug("writeCore", zap.Int("pid", pid), zap.Int("threads", len(threads)),
+		zap.Int("mappings", len(mappings)), zap.Uint64("size", offset))
+	return nil
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
 // This table is produced by go compiler and linker. copy from https://golang.org/pkg/cmd/internal/dwarf/
 const (
 	DW_OP_addr                = 0x03 // 1 constant address (size target specific)
@@ -64,6 +70,388 @@ const (
 	DW_OP_form_tls_address    = 0x9b // 0
 	DW_OP_call_frame_cfa      = 0x9c // 0
 	DW_OP_bit_piece           = 0x9d // 2
+	DW_OP_implicit_value      = 0x9e // 2 ULEB128 size followed by a block of that size
+	DW_OP_stack_value         = 0x9f // 0
 	DW_OP_lo_user             = 0xe0
 	DW_OP_hi_user             = 0xff
 )
+
+// execDwarfExpression evaluates a DWARF expression and returns the top of the stack.
+// regs are the DWARF numbered registers, cfa is pushed by DW_OP_call_frame_cfa,
+// initial is pushed on the stack before evaluating, as DW_CFA_expression requires.
+func execDwarfExpression(pid int, expr []byte, regs []uint64, cfa uint64, initial ...uint64) (uint64, error) {
+	var (
+		opcode byte
+		err    error
+	)
+	stack := append(make([]uint64, 0, 8), initial...)
+	pop := func() (uint64, error) {
+		if len(stack) == 0 {
+			return 0, fmt.Errorf("dwarf expression stack is empty")
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+	reg := func(n uint64) (uint64, error) {
+		if n >= uint64(len(regs)) {
+			return 0, fmt.Errorf("dwarf expression uses unsupported register %d", n)
+		}
+		return regs[n], nil
+	}
+
+	buf := bytes.NewBuffer(expr)
+	// the operands of the opcode may be truncated in a corrupted expression
+	truncatedErr := func() error {
+		return fmt.Errorf("dwarf expression opcode 0x%x is truncated", opcode)
+	}
+	next := func(n int) ([]byte, error) {
+		if buf.Len() < n {
+			return nil, truncatedErr()
+		}
+		return buf.Next(n), nil
+	}
+	uleb := func() (uint64, error) {
+		v, _, err := DecodeULEB128(buf)
+		if err != nil {
+			return 0, truncatedErr()
+		}
+		return v, nil
+	}
+	sleb := func() (int64, error) {
+		v, _, err := DecodeSLEB128(buf)
+		if err != nil {
+			return 0, truncatedErr()
+		}
+		return v, nil
+	}
+	for buf.Len() > 0 {
+		if opcode, err = buf.ReadByte(); err != nil {
+			return 0, err
+		}
+		switch {
+		case opcode >= DW_OP_lit0 && opcode <= DW_OP_lit31:
+			stack = append(stack, uint64(opcode-DW_OP_lit0))
+			continue
+		case opcode >= DW_OP_reg0 && opcode <= DW_OP_reg31:
+			v, err := reg(uint64(opcode - DW_OP_reg0))
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, v)
+			continue
+		case opcode >= DW_OP_breg0 && opcode <= DW_OP_breg31:
+			v, err := reg(uint64(opcode - DW_OP_breg0))
+			if err != nil {
+				return 0, err
+			}
+			offset, err := sleb()
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, uint64(int64(v)+offset))
+			continue
+		}
+
+		switch opcode {
+		case DW_OP_addr, DW_OP_const8u, DW_OP_const8s:
+			b, err := next(8)
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, binary.LittleEndian.Uint64(b))
+		case DW_OP_const1u, DW_OP_const1s:
+			b, err := next(1)
+			if err != nil {
+				return 0, err
+			}
+			if opcode == DW_OP_const1s {
+				stack = append(stack, uint64(int8(b[0])))
+			} else {
+				stack = append(stack, uint64(b[0]))
+			}
+		case DW_OP_const2u, DW_OP_const2s:
+			b, err := next(2)
+			if err != nil {
+				return 0, err
+			}
+			if opcode == DW_OP_const2s {
+				stack = append(stack, uint64(int16(binary.LittleEndian.Uint16(b))))
+			} else {
+				stack = append(stack, uint64(binary.LittleEndian.Uint16(b)))
+			}
+		case DW_OP_const4u, DW_OP_const4s:
+			b, err := next(4)
+			if err != nil {
+				return 0, err
+			}
+			if opcode == DW_OP_const4s {
+				stack = append(stack, uint64(int32(binary.LittleEndian.Uint32(b))))
+			} else {
+				stack = append(stack, uint64(binary.LittleEndian.Uint32(b)))
+			}
+		case DW_OP_constu:
+			v, err := uleb()
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, v)
+		case DW_OP_consts:
+			v, err := sleb()
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, uint64(v))
+		case DW_OP_regx:
+			n, err := uleb()
+			if err != nil {
+				return 0, err
+			}
+			v, err := reg(n)
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, v)
+		case DW_OP_bregx:
+			n, err := uleb()
+			if err != nil {
+				return 0, err
+			}
+			offset, err := sleb()
+			if err != nil {
+				return 0, err
+			}
+			v, err := reg(n)
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, uint64(int64(v)+offset))
+		case DW_OP_call_frame_cfa:
+			stack = append(stack, cfa)
+		case DW_OP_fbreg:
+			// the frame base of go functions is DW_OP_call_frame_cfa
+			offset, err := sleb()
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, uint64(int64(cfa)+offset))
+		case DW_OP_dup:
+			if len(stack) == 0 {
+				return 0, fmt.Errorf("dwarf expression stack is empty")
+			}
+			stack = append(stack, stack[len(stack)-1])
+		case DW_OP_drop:
+			if _, err = pop(); err != nil {
+				return 0, err
+			}
+		case DW_OP_over, DW_OP_pick:
+			index := 1
+			if opcode == DW_OP_pick {
+				b, err := next(1)
+				if err != nil {
+					return 0, err
+				}
+				index = int(b[0])
+			}
+			if index >= len(stack) {
+				return 0, fmt.Errorf("dwarf expression pick %d out of stack", index)
+			}
+			stack = append(stack, stack[len(stack)-1-index])
+		case DW_OP_swap:
+			if len(stack) < 2 {
+				return 0, fmt.Errorf("dwarf expression stack is empty")
+			}
+			n := len(stack)
+			stack[n-1], stack[n-2] = stack[n-2], stack[n-1]
+		case DW_OP_rot:
+			if len(stack) < 3 {
+				return 0, fmt.Errorf("dwarf expression stack is empty")
+			}
+			n := len(stack)
+			stack[n-1], stack[n-2], stack[n-3] = stack[n-2], stack[n-3], stack[n-1]
+		case DW_OP_deref, DW_OP_deref_size:
+			size := byte(8)
+			if opcode == DW_OP_deref_size {
+				b, err := next(1)
+				if err != nil {
+					return 0, err
+				}
+				size = b[0]
+			}
+			addr, err := pop()
+			if err != nil {
+				return 0, err
+			}
+			v, err := readUint64(pid, addr)
+			if err != nil {
+				return 0, err
+			}
+			if size < 8 {
+				v &= (1 << (uint(size) * 8)) - 1
+			}
+			stack = append(stack, v)
+		case DW_OP_plus_uconst:
+			v, err := uleb()
+			if err != nil {
+				return 0, err
+			}
+			a, err := pop()
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, a+v)
+		case DW_OP_abs, DW_OP_neg, DW_OP_not:
+			a, err := pop()
+			if err != nil {
+				return 0, err
+			}
+			switch opcode {
+			case DW_OP_abs:
+				if int64(a) < 0 {
+					a = uint64(-int64(a))
+				}
+			case DW_OP_neg:
+				a = uint64(-int64(a))
+			case DW_OP_not:
+				a = ^a
+			}
+			stack = append(stack, a)
+		case DW_OP_and, DW_OP_div, DW_OP_minus, DW_OP_mod, DW_OP_mul, DW_OP_or, DW_OP_plus,
+			DW_OP_shl, DW_OP_shr, DW_OP_shra, DW_OP_xor,
+			DW_OP_eq, DW_OP_ge, DW_OP_gt, DW_OP_le, DW_OP_lt, DW_OP_ne:
+			b, err := pop()
+			if err != nil {
+				return 0, err
+			}
+			a, err := pop()
+			if err != nil {
+				return 0, err
+			}
+			v, err := binaryDwarfOp(opcode, a, b)
+			if err != nil {
+				return 0, err
+			}
+			stack = append(stack, v)
+		case DW_OP_skip, DW_OP_bra:
+			b, err := next(2)
+			if err != nil {
+				return 0, err
+			}
+			offset := int16(binary.LittleEndian.Uint16(b))
+			if opcode == DW_OP_bra {
+				v, err := pop()
+				if err != nil {
+					return 0, err
+				}
+				if v == 0 {
+					continue
+				}
+			}
+			pos := len(expr) - buf.Len() + int(offset)
+			if pos < 0 || pos > len(expr) {
+				return 0, fmt.Errorf("dwarf expression branches out of range")
+			}
+			buf = bytes.NewBuffer(expr[pos:])
+		case DW_OP_nop, DW_OP_stack_value:
+		default:
+			return 0, fmt.Errorf("not support dwarf expression opcode 0x%x", opcode)
+		}
+	}
+	return pop()
+}
+
+func binaryDwarfOp(opcode byte, a, b uint64) (uint64, error) {
+	boolValue := func(v bool) uint64 {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	switch opcode {
+	case DW_OP_and:
+		return a & b, nil
+	case DW_OP_div:
+		if b == 0 {
+			return 0, fmt.Errorf("dwarf expression divides by zero")
+		}
+		return uint64(int64(a) / int64(b)), nil
+	case DW_OP_minus:
+		return a - b, nil
+	case DW_OP_mod:
+		if b == 0 {
+			return 0, fmt.Errorf("dwarf expression divides by zero")
+		}
+		return a % b, nil
+	case DW_OP_mul:
+		return a * b, nil
+	case DW_OP_or:
+		return a | b, nil
+	case DW_OP_plus:
+		return a + b, nil
+	case DW_OP_shl:
+		return a << b, nil
+	case DW_OP_shr:
+		return a >> b, nil
+	case DW_OP_shra:
+		return uint64(int64(a) >> b), nil
+	case DW_OP_xor:
+		return a ^ b, nil
+	case DW_OP_eq:
+		return boolValue(int64(a) == int64(b)), nil
+	case DW_OP_ge:
+		return boolValue(int64(a) >= int64(b)), nil
+	case DW_OP_gt:
+		return boolValue(int64(a) > int64(b)), nil
+	case DW_OP_le:
+		return boolValue(int64(a) <= int64(b)), nil
+	case DW_OP_lt:
+		return boolValue(int64(a) < int64(b)), nil
+	case DW_OP_ne:
+		return boolValue(int64(a) != int64(b)), nil
+	}
+	return 0, fmt.Errorf("not support dwarf expression opcode 0x%x", opcode)
+}
+
+// nextDwarfOp reads one operation of a DWARF expression, and returns the opcode with its raw operands.
+func nextDwarfOp(buf *bytes.Buffer) (byte, []byte, error) {
+	opcode, err := buf.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n uint32
+	operands := buf.Bytes()
+	size := 0
+	switch {
+	case opcode >= DW_OP_breg0 && opcode <= DW_OP_breg31:
+		_, n, _ = DecodeSLEB128(bytes.NewBuffer(operands))
+		size = int(n)
+	case opcode == DW_OP_addr, opcode == DW_OP_const8u, opcode == DW_OP_const8s:
+		size = 8
+	case opcode == DW_OP_const1u, opcode == DW_OP_const1s, opcode == DW_OP_pick,
+		opcode == DW_OP_deref_size, opcode == DW_OP_xderef_size:
+		size = 1
+	case opcode == DW_OP_const2u, opcode == DW_OP_const2s, opcode == DW_OP_skip,
+		opcode == DW_OP_bra, opcode == DW_OP_call2:
+		size = 2
+	case opcode == DW_OP_const4u, opcode == DW_OP_const4s, opcode == DW_OP_call4, opcode == DW_OP_call_ref:
+		size = 4
+	case opcode == DW_OP_constu, opcode == DW_OP_plus_uconst, opcode == DW_OP_regx, opcode == DW_OP_piece:
+		_, n, _ = DecodeULEB128(bytes.NewBuffer(operands))
+		size = int(n)
+	case opcode == DW_OP_consts, opcode == DW_OP_fbreg:
+		_, n, _ = DecodeSLEB128(bytes.NewBuffer(operands))
+		size = int(n)
+	case opcode == DW_OP_bregx, opcode == DW_OP_bit_piece:
+		ops := bytes.NewBuffer(operands)
+		_, n1, _ := DecodeULEB128(ops)
+		_, n2, _ := DecodeULEB128(ops)
+		size = int(n1 + n2)
+	case opcode == DW_OP_implicit_value:
+		length, n1, _ := DecodeULEB128(bytes.NewBuffer(operands))
+		size = int(n1) + int(length)
+	}
+	if size > len(operands) {
+		return 0, nil, fmt.Errorf("dwarf expression opcode 0x%x is truncated", opcode)
+	}
+	return opcode, buf.Next(size), nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"strings"
+)
+
+// pointer encodings of .eh_frame, https://refspecs.linuxfoundation.org/LSB_5.0.0/LSB-Core-generic/LSB-Core-generic/ehframechpt.html
+const (
+	DW_EH_PE_absptr  = 0x00
+	DW_EH_PE_uleb128 = 0x01
+	DW_EH_PE_udata2  = 0x02
+	DW_EH_PE_udata4  = 0x03
+	DW_EH_PE_udata8  = 0x04
+	DW_EH_PE_sleb128 = 0x09
+	DW_EH_PE_sdata2  = 0x0a
+	DW_EH_PE_sdata4  = 0x0b
+	DW_EH_PE_sdata8  = 0x0c
+
+	DW_EH_PE_pcrel   = 0x10
+	DW_EH_PE_textrel = 0x20
+	DW_EH_PE_datarel = 0x30
+	DW_EH_PE_funcrel = 0x40
+	DW_EH_PE_aligned = 0x50
+
+	DW_EH_PE_indirect = 0x80
+	DW_EH_PE_omit     = 0xff
+)
+
+// ehSection is a section which contains encoded pointers, addr is the virtual address of data[0].
+type ehSection struct {
+	data []byte
+	addr uint64
+}
+
+// readEncodedPointer reads a pointer of the encoding at buf, pos is the virtual address of the pointer,
+// which is the base of DW_EH_PE_pcrel; datarel is the base of DW_EH_PE_datarel.
+func readEncodedPointer(buf *bytes.Buffer, encoding byte, pos uint64, datarel uint64) (uint64, error) {
+	var (
+		res uint64
+		err error
+	)
+	if encoding == DW_EH_PE_omit {
+		return 0, nil
+	}
+
+	switch encoding & 0x0f {
+	case DW_EH_PE_absptr, DW_EH_PE_udata8, DW_EH_PE_sdata8:
+		var v uint64
+		err = binary.Read(buf, binary.LittleEndian, &v)
+		res = v
+	case DW_EH_PE_uleb128:
+		res, _, err = DecodeULEB128(buf)
+	case DW_EH_PE_sleb128:
+		var v int64
+		v, _, err = DecodeSLEB128(buf)
+		res = uint64(v)
+	case DW_EH_PE_udata2:
+		var v uint16
+		err = binary.Read(buf, binary.LittleEndian, &v)
+		res = uint64(v)
+	case DW_EH_PE_sdata2:
+		var v int16
+		err = binary.Read(buf, binary.LittleEndian, &v)
+		res = uint64(v)
+	case DW_EH_PE_udata4:
+		var v uint32
+		err = binary.Read(buf, binary.LittleEndian, &v)
+		res = uint64(v)
+	case DW_EH_PE_sdata4:
+		var v int32
+		err = binary.Read(buf, binary.LittleEndian, &v)
+		res = uint64(v)
+	default:
+		return 0, fmt.Errorf("not support pointer encoding 0x%x", encoding)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	switch encoding & 0x70 {
+	case DW_EH_PE_absptr:
+	case DW_EH_PE_pcrel:
+		res += pos
+	case DW_EH_PE_datarel:
+		res += datarel
+	default:
+		return 0, fmt.Errorf("not support pointer application 0x%x", encoding&0x70)
+	}
+	if encoding&DW_EH_PE_indirect != 0 {
+		return 0, fmt.Errorf("not support indirect pointer encoding 0x%x", encoding)
+	}
+	return res, nil
+}
+
+// parseEHFrameSection parses the .eh_frame section, which is the .debug_frame with augmentations,
+// the addresses of fde are encoded by the `R` augmentation and are mostly pc-relative.
+func parseEHFrameSection(section *ehSection) ([]*VirtualUnwindFrameInformation, error) {
+	var (
+		infos  []*VirtualUnwindFrameInformation
+		cies   = make(map[uint64]*CommonInformationEntry)
+		offset uint64
+	)
+	for offset+4 <= uint64(len(section.data)) {
+		start := offset
+		length := uint64(binary.LittleEndian.Uint32(section.data[offset:]))
+		offset += 4
+		if length == 0 {
+			// the terminator
+			break
+		}
+		if length == 0xffffffff {
+			if offset+8 > uint64(len(section.data)) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			length = binary.LittleEndian.Uint64(section.data[offset:])
+			offset += 8
+		}
+		end := offset + length
+		if end > uint64(len(section.data)) || length < 4 {
+			return nil, fmt.Errorf("invalid .eh_frame entry at offset 0x%x", start)
+		}
+		idpos := offset
+		id := uint64(binary.LittleEndian.Uint32(section.data[offset:]))
+		offset += 4
+		input := section.data[offset:end]
+
+		if id == 0 {
+			cie, err := parseEHFrameCIE(uint32(length), input)
+			if err != nil {
+				return nil, err
+			}
+			cies[start] = cie
+			infos = append(infos, &VirtualUnwindFrameInformation{len: uint32(length), CIE: cie})
+		} else {
+			// the id of fde is the distance from itself back to its cie
+			cie, ok := cies[idpos-id]
+			if !ok {
+				return nil, fmt.Errorf("can't find the cie of fde at offset 0x%x in .eh_frame", start)
+			}
+			fde, err := parseEHFrameFDE(uint32(length), input, cie, section.addr+offset)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, &VirtualUnwindFrameInformation{len: uint32(length), FDE: fde})
+		}
+		offset = end
+	}
+	return infos, nil
+}
+
+func parseEHFrameCIE(length uint32, data []byte) (*CommonInformationEntry, error) {
+	var (
+		err error
+		str string
+	)
+	cie := &CommonInformationEntry{length: length, ptrEncoding: DW_EH_PE_absptr, lsdaEncoding: DW_EH_PE_omit}
+	buf := bytes.NewBuffer(data)
+
+	if cie.version, err = buf.ReadByte(); err != nil {
+		return nil, err
+	}
+	if str, err = buf.ReadString(0x0); err != nil {
+		return nil, err
+	}
+	cie.augmentation = strings.TrimSuffix(str, "\x00")
+
+	if strings.Contains(cie.augmentation, "eh") {
+		// the eh_ptr of the old gcc
+		buf.Next(8)
+	}
+	if cie.code_alignment_factor, _, err = DecodeULEB128(buf); err != nil {
+		return nil, err
+	}
+	if cie.data_alignment_factor, _, err = DecodeSLEB128(buf); err != nil {
+		return nil, err
+	}
+	if cie.version == 1 {
+		var reg byte
+		if reg, err = buf.ReadByte(); err != nil {
+			return nil, err
+		}
+		cie.return_address_register = uint64(reg)
+	} else if cie.return_address_register, _, err = DecodeULEB128(buf); err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(cie.augmentation, "z") {
+		var size uint64
+		if size, _, err = DecodeULEB128(buf); err != nil {
+			return nil, err
+		}
+		augbuf := bytes.NewBuffer(buf.Next(int(size)))
+		for _, c := range cie.augmentation[1:] {
+			switch c {
+			case 'R':
+				if cie.ptrEncoding, err = augbuf.ReadByte(); err != nil {
+					return nil, err
+				}
+			case 'L':
+				if cie.lsdaEncoding, err = augbuf.ReadByte(); err != nil {
+					return nil, err
+				}
+			case 'P':
+				var encoding byte
+				if encoding, err = augbuf.ReadByte(); err != nil {
+					return nil, err
+				}
+				// the personality routine isn't used by the unwinder, it is skipped by the format of its encoding,
+				// so that an indirect pointer isn't dereferenced
+				if encoding == DW_EH_PE_omit {
+					continue
+				}
+				if _, err = readEncodedPointer(augbuf, encoding&0x0f, 0, 0); err != nil {
+					return nil, err
+				}
+			case 'S':
+				cie.signalFrame = true
+			default:
+				// the remaining augmentation data can't be understood, but its size is known
+				logger.Debug("parseEHFrameCIE unknown augmentation", zap.String("augmentation", cie.augmentation))
+			}
+		}
+	}
+	cie.initial_instructions = buf.Bytes()
+	return cie, nil
+}
+
+// parseEHFrameFDE parses the fde, addr is the virtual address of data[0].
+func parseEHFrameFDE(length uint32, data []byte, cie *CommonInformationEntry, addr uint64) (*FrameDescriptionEntry, error) {
+	var err error
+	fde := &FrameDescriptionEntry{length: length, CIE: cie}
+	buf := bytes.NewBuffer(data)
+
+	if fde.begin, err = readEncodedPointer(buf, cie.ptrEncoding, addr, 0); err != nil {
+		return nil, err
+	}
+	// the range is a length, so only the format of the encoding matters
+	if fde.size, err = readEncodedPointer(buf, cie.ptrEncoding&0x0f, 0, 0); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(cie.augmentation, "z") {
+		var size uint64
+		if size, _, err = DecodeULEB128(buf); err != nil {
+			return nil, err
+		}
+		buf.Next(int(size))
+	}
+	fde.instructions = buf.Bytes()
+	fde.instructionsEnd = addr + uint64(len(data))
+
+	logger.Debug("parseEHFrameFDE",
+		zap.Uint32("len", length),
+		zap.Uint64("begin", fde.begin),
+		zap.Uint64("size", fde.size))
+	return fde, nil
+}
+
+// parseEHFrameHdr returns the address of .eh_frame which is recorded in .eh_frame_hdr,
+// and the number of entries in its binary search table.
+func parseEHFrameHdr(section *ehSection) (uint64, uint64, error) {
+	var (
+		ehFramePtr uint64
+		fdeCount   uint64
+		err        error
+	)
+	if len(section.data) < 4 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	if version := section.data[0]; version != 1 {
+		return 0, 0, fmt.Errorf("not support .eh_frame_hdr version %d", version)
+	}
+	ptrEncoding, countEncoding := section.data[1], section.data[2]
+	buf := bytes.NewBuffer(section.data[4:])
+	if ehFramePtr, err = readEncodedPointer(buf, ptrEncoding, section.addr+4, section.addr); err != nil {
+		return 0, 0, err
+	}
+	pos := section.addr + uint64(len(section.data)-buf.Len())
+	if fdeCount, err = readEncodedPointer(buf, countEncoding, pos, section.addr); err != nil {
+		return 0, 0, err
+	}
+	return ehFramePtr, fdeCount, nil
+}
+
+// openEHFrameSection finds .eh_frame, the section headers may be missing,
+// so the address recorded in .eh_frame_hdr is used to find it too.
+func openEHFrameSection(elffile *elf.File) (*ehSection, error) {
+	var (
+		hdr        *ehSection
+		ehFramePtr uint64
+		fdeCount   uint64
+		err        error
+	)
+	if s := elffile.Section(".eh_frame_hdr"); s != nil {
+		hdr = &ehSection{addr: s.Addr}
+		if hdr.data, err = s.Data(); err != nil {
+			return nil, err
+		}
+		if ehFramePtr, fdeCount, err = parseEHFrameHdr(hdr); err != nil {
+			return nil, err
+		}
+		logger.Debug("openEHFrameSection", zap.Uint64("eh_frame_ptr", ehFramePtr), zap.Uint64("fde_count", fdeCount))
+	}
+
+	s := elffile.Section(".eh_frame")
+	if s == nil && hdr != nil {
+		for _, section := range elffile.Sections {
+			if section.Addr == ehFramePtr && section.Type == elf.SHT_PROGBITS {
+				s = section
+				break
+			}
+		}
+	}
+	if s == nil {
+		return nil, nil
+	}
+	section := &ehSection{addr: s.Addr}
+	if section.data, err = s.Data(); err != nil {
+		return nil, err
+	}
+	return section, nil
+}
@@ -8,6 +8,14 @@ import (
 var NotFoundSourceLineErr = errors.New("cant't find this source line")
 var HasExistedBreakPointErr = errors.New("this breakpoint has existed")
 var NoProcessRuning = errors.New("there is no process running")
+var NotRecordingErr = errors.New("the process is not being recorded, please `record` first")
+var NoRecordHistoryErr = errors.New("no more reverse-execution history")
+var InitialFrameErr = errors.New("initial frame selected, you can't go down")
+var OutermostFrameErr = errors.New("outermost frame selected, you can't go up")
+var OptimizedOutErr = errors.New("optimized out")
+var CoreReadOnlyErr = errors.New("the core is read-only, only the commands which inspect the process work")
+var RestartAttachedErr = errors.New("the attached process can't be restarted, please `detach` first")
+var UnterminatedQuoteErr = errors.New("the quote or the `\\` of the arguments is unterminated")
 
 type NotFoundFuncErr struct {
 	pc uint64
@@ -17,8 +25,24 @@ func (e *NotFoundFuncErr) Error() string {
 	return fmt.Sprintf("findFunctionIncludePc can't find function by pc:%d", e.pc)
 }
 
+type NotFoundFrameErr struct {
+	pc uint64
+}
+
+func (e *NotFoundFrameErr) Error() string {
+	return fmt.Sprintf("not find the frame cover pc = 0x%x", e.pc)
+}
+
+type NotFoundVariableErr struct {
+	name string
+}
+
+func (e *NotFoundVariableErr) Error() string {
+	return fmt.Sprintf("not find the variable `%s` in the current frame", e.name)
+}
+
 func printExecutableProgramHelper() {
-	fmt.Fprintf(stderr, "%s\n", "Usage:\n\tJust like `godbg debug ./main.go`.\n\tThe `main.go` is the file which you want debug.")
+	fmt.Fprintf(stderr, "%s\n", "Usage:\n\tJust like `godbg debug [-O] [-tags tags] [-mod mode] [-race] [-gcflags flags] [-ldflags flags] ./main.go [-- args]`.\n\tThe `main.go` is the file which you want debug, it can be the directory or the import path of the main package too.\n\t-O keeps the optimizations of the compiler, some variables may be optimized out.\n\tThe program runs with `-env KEY=VAL` (repeatable), `-wd dir`, `-stdin file`, `-stdout file`, `-stderr file` or `-tty /dev/pts/N` before the file, and the arguments after `--`.\n\tOr `godbg test [-run regexp] [-v] [./pkg]` to debug the tests of the package.\n\tOr `godbg exec [-env KEY=VAL] [-wd dir] ./mybinary [-- args]` to debug the prebuilt executable file.\n\tOr `godbg attach <pid>` to debug the running process.\n\tOr `godbg core ./mybinary ./core` to inspect the core file which is dumped by the binary.")
 }
 
 // printCmdHelper print all usages of cmd.
@@ -30,14 +54,31 @@ func printCmdHelper() {
 		"\t b  (break) <filename:line>  ----   set an breakpoint at specific the line of filename.\n"+
 		"\t bc (bclear) all             ----   clear all breakpoints.\n"+
 		"\t bl [all]                    ----   list all breakpoints if `all`.\n"+
-		"\t bt                          ----   show call stack.\n"+
-		"\t c  (continue)               ----   continue the paused programe.\n"+
-		"\t s  (step)                   ----   step one instruction.\n"+
-		"\t n  (next)                   ----   next step for source code.\n"+
-		"\t l  (list) <filename:line>   ----   show the code for specific the line of filename.\n"+
-		"\t r  (restart)                ----   restart the traced programe.\n"+
+		"\t bt [depth] [-args] [-full]  ----   show call stack, with the arguments if `-args`, and the locals if `-full`.\n"+
+		"\t frame <n>                   ----   select the frame `n` of the call stack.\n"+
+		"\t up [n]                      ----   select the frame `n` levels up, the caller.\n"+
+		"\t down [n]                    ----   select the frame `n` levels down, the callee.\n"+
+		"\t c  (continue) [count]       ----   continue the paused programe, `count` times.\n"+
+		"\t s  (step) [count]           ----   step one source line, enter function calls.\n"+
+		"\t n  (next) [count]           ----   next step for source code.\n"+
+		"\t skip [add|del <pattern>]    ----   list or change the functions which `step` doesn't stop in.\n"+
+		"\t handle [sig] [keywords]     ----   list or change how the signal is handled, by stop|nostop|print|noprint|pass|nopass.\n"+
+		"\t si (stepi) [count]          ----   step one instruction.\n"+
+		"\t ni (nexti) [count]          ----   step one instruction, but step over calls.\n"+
+		"\t l  (list) [filename:line]   ----   show the code for specific the line of filename, or the selected frame.\n"+
+		"\t r  (restart) [args|--]      ----   restart the traced programe, with new arguments, or none if `--`, quote the argument with spaces.\n"+
+		"\t detach                      ----   remove all breakpoints and release the traced process.\n"+
+		"\t dump <file>                 ----   write the core of the stopped process, which is read by `godbg core`.\n"+
+		"\t record [size|stop]          ----   record the executed instructions, keep the newest `size`.\n"+
+		"\t rsi (reverse-stepi)         ----   step one instruction backward.\n"+
+		"\t rn (reverse-next)           ----   next step for source code backward.\n"+
+		"\t rc (reverse-continue)       ----   continue backward to the previous breakpoint.\n"+
 		"\t disass (disassemble)        ----   show the asm at cur breakpoint.\n"+
 		"\t p  (print) <varibale>       ----   print the variable.but just support string type for now.\n"+
+		"\t locals                      ----   print the local variables of the selected frame.\n"+
+		"\t args                        ----   print the arguments of the selected frame.\n"+
+		"\t goroutines                  ----   list the goroutines, the one of the current thread is marked by `*`.\n"+
+		"\t x <addr> [count]            ----   show `count` bytes of the memory at addr, 16 by default.\n"+
 		"\t h  (help)                   ----   show the usage for cmd.\n")
 }
 
@@ -53,6 +94,10 @@ func printNotFoundSourceLineErr(place string) {
 	fmt.Fprintf(stderr, "can't find this source line %s\n", place)
 }
 
+func printWarning(msg string) {
+	fmt.Fprintf(stderr, "warning: %s\n", msg)
+}
+
 func printErr(err error) {
 	fmt.Fprintf(stderr, "%s\n", err.Error())
 }
@@ -18,6 +18,9 @@ type CommonInformationEntry struct {
 	data_alignment_factor   int64
 	return_address_register uint64
 	initial_instructions    []byte
+	ptrEncoding             byte // the `R` augmentation of .eh_frame, the encoding of addresses in fde
+	lsdaEncoding            byte // the `L` augmentation of .eh_frame
+	signalFrame             bool // the `S` augmentation of .eh_frame
 	// `padding`, Enough DW_CFA_nop instructions to make the size of this entry match the length value above.
 }
 
@@ -36,10 +39,11 @@ func (cie *CommonInformationEntry) String() string {
 }
 
 type FrameDescriptionEntry struct {
-	length       uint32
-	CIE          *CommonInformationEntry
-	instructions []byte
-	begin, size  uint64
+	length          uint32
+	CIE             *CommonInformationEntry
+	instructions    []byte
+	instructionsEnd uint64 // the virtual address of the end of instructions in .eh_frame, the base of pcrel DW_CFA_set_loc
+	begin, size     uint64
 }
 
 func (fde *FrameDescriptionEntry) String() string {
@@ -57,15 +61,18 @@ type VirtualUnwindFrameInformation struct {
 }
 
 type Frame struct {
-	instructions []byte
-	address      uint64
-	cie          *CommonInformationEntry
-	Offset       int64
-	cfa          *DWRule
-	regsRule     map[uint64]DWRule
-	regs         []uint64
-	framebase    uint64
-	loc          uint64
+	instructions    []byte
+	address         uint64
+	cie             *CommonInformationEntry
+	Offset          int64
+	cfa             *DWRule
+	regsRule        map[uint64]DWRule
+	initialRegsRule map[uint64]DWRule
+	states          []frameState
+	regs            []uint64
+	framebase       uint64
+	loc             uint64
+	instructionsEnd uint64 // the virtual address of the end of the fde instructions
 }
 
 func parseFrameInformation(buffer *bytes.Buffer) (*VirtualUnwindFrameInformation, error) {
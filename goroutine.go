@@ -0,0 +1,207 @@
+package main
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// the status of goroutines in runtime2.go, _Gscan (0x1000) is cleared before looking up
+var goroutineStatus = map[uint64]string{
+	0: "idle", 1: "runnable", 2: "running", 3: "syscall", 4: "waiting", 6: "dead",
+	8: "copystack", 9: "preempted", 10: "leaked", 11: "dead",
+}
+
+// goroutine is a `runtime.g` of allgs.
+type goroutine struct {
+	addr   uint64
+	id     uint64
+	status uint64
+	pc     uint64 // g.sched.pc, or the pc of the thread which runs it
+	thread int    // the thread which runs it, 0 if it isn't running
+}
+
+// globalVariable returns the entry of the package level variable name, like `runtime.allgs`.
+func (bi *BI) globalVariable(name string) (*dwarf.Entry, error) {
+	reader := bi.DwarfData.Reader()
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("not find the variable `%s`", name)
+		}
+		switch entry.Tag {
+		case dwarf.TagCompileUnit:
+		case dwarf.TagVariable:
+			if entry.Val(dwarf.AttrName) == name {
+				return entry, nil
+			}
+		default:
+			reader.SkipChildren()
+		}
+	}
+}
+
+// fieldOffset returns the offset and the type of the field path of typ, like `sched.pc`.
+func fieldOffset(typ dwarf.Type, path ...string) (int64, dwarf.Type, error) {
+	var offset int64
+	for _, name := range path {
+		for {
+			t, ok := typ.(*dwarf.TypedefType)
+			if !ok {
+				break
+			}
+			typ = t.Type
+		}
+		st, ok := typ.(*dwarf.StructType)
+		if !ok {
+			return 0, nil, fmt.Errorf("%s isn't a struct", typ.String())
+		}
+		found := false
+		for _, field := range st.Field {
+			if field.Name == name {
+				offset += field.ByteOffset
+				typ = field.Type
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, nil, fmt.Errorf("not find the field `%s` of %s", name, st.StructName)
+		}
+	}
+	return offset, typ, nil
+}
+
+// goroutines reads the goroutines which aren't dead from `runtime.allgs`.
+func (bi *BI) goroutines(pid int) ([]*goroutine, error) {
+	var (
+		entry *dwarf.Entry
+		typ   dwarf.Type
+		err   error
+	)
+	if entry, err = bi.globalVariable("runtime.allgs"); err != nil {
+		return nil, err
+	}
+	loc, ok := entry.Val(dwarf.AttrLocation).([]byte)
+	if !ok || len(loc) != 9 || loc[0] != DW_OP_addr {
+		return nil, errors.New("runtime.allgs isn't at a static address")
+	}
+	off, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return nil, errors.New("runtime.allgs has no type")
+	}
+	if typ, err = bi.DwarfData.Type(off); err != nil {
+		return nil, err
+	}
+
+	// allgs is []*runtime.g, which is {array, len, cap}
+	slice := make([]byte, 24)
+	if _, err = readMemory(pid, binary.LittleEndian.Uint64(loc[1:]), slice); err != nil {
+		return nil, err
+	}
+	array, length := binary.LittleEndian.Uint64(slice), binary.LittleEndian.Uint64(slice[8:])
+	_, arrayType, err := fieldOffset(typ, "array")
+	if err != nil {
+		return nil, err
+	}
+	ptr, ok := arrayType.(*dwarf.PtrType)
+	if !ok {
+		return nil, errors.New("runtime.allgs isn't a slice of pointers")
+	}
+	gptr, ok := ptr.Type.(*dwarf.PtrType)
+	if !ok {
+		return nil, errors.New("runtime.allgs isn't a slice of pointers")
+	}
+	goidOffset, _, err := fieldOffset(gptr.Type, "goid")
+	if err != nil {
+		return nil, err
+	}
+	// atomicstatus is uint32, or atomic.Uint32 which is a struct of uint32
+	statusOffset, _, err := fieldOffset(gptr.Type, "atomicstatus")
+	if err != nil {
+		return nil, err
+	}
+	pcOffset, _, err := fieldOffset(gptr.Type, "sched", "pc")
+	if err != nil {
+		return nil, err
+	}
+
+	// the running goroutines are in the TLS slot at fs_base-8 of their threads
+	threads := make(map[uint64]int)
+	for _, tid := range currentProcess().Threads() {
+		regs, err := currentProcess().Registers(tid)
+		if err != nil {
+			return nil, err
+		}
+		if g, err := readUint64(pid, regs.Fs_base-8); err == nil && g != 0 {
+			threads[g] = tid
+		}
+	}
+
+	gs := make([]*goroutine, 0, length)
+	for i := uint64(0); i < length; i++ {
+		g := &goroutine{}
+		if g.addr, err = readUint64(pid, array+8*i); err != nil {
+			return nil, err
+		}
+		if g.id, err = readUint64(pid, g.addr+uint64(goidOffset)); err != nil {
+			return nil, err
+		}
+		status := make([]byte, 4)
+		if _, err = readMemory(pid, g.addr+uint64(statusOffset), status); err != nil {
+			return nil, err
+		}
+		g.status = uint64(binary.LittleEndian.Uint32(status)) &^ 0x1000
+		if goroutineStatus[g.status] == "dead" {
+			continue
+		}
+		if g.pc, err = readUint64(pid, g.addr+uint64(pcOffset)); err != nil {
+			return nil, err
+		}
+		if tid, ok := threads[g.addr]; ok {
+			regs, err := currentProcess().Registers(tid)
+			if err != nil {
+				return nil, err
+			}
+			g.pc, g.thread = regs.PC(), tid
+		}
+		gs = append(gs, g)
+	}
+	return gs, nil
+}
+
+// printGoroutines prints the goroutines, the one of the current thread is marked by `*`.
+func (bi *BI) printGoroutines(pid int) error {
+	gs, err := bi.goroutines(pid)
+	if err != nil {
+		return err
+	}
+	for _, g := range gs {
+		selected := " "
+		if g.thread != 0 && g.thread == currentThread() {
+			selected = "*"
+		}
+		status, ok := goroutineStatus[g.status]
+		if !ok {
+			status = fmt.Sprintf("status %d", g.status)
+		}
+		name := "?"
+		if f, err := bi.findFunctionIncludePc(g.pc); err == nil {
+			name = f.name
+		}
+		location := "?"
+		if filename, line, err := bi.pcTofileLine(g.pc); err == nil {
+			location = fmt.Sprintf("%s:%d", filename, line)
+		}
+		thread := ""
+		if g.thread != 0 {
+			thread = fmt.Sprintf(" (thread %d)", g.thread)
+		}
+		fmt.Fprintf(stdout, "%sGoroutine %d - %s - pc=0x%x %s %s%s\n", selected, g.id, status, g.pc, location, name, thread)
+	}
+	return nil
+}
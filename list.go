@@ -15,6 +15,10 @@ func listFileLineByPtracePc(bi *BI, rangeline int) error {
 	if err != nil {
 		return err
 	}
+	return listFileLineByPc(bi, pc, rangeline)
+}
+
+func listFileLineByPc(bi *BI, pc uint64, rangeline int) error {
 	filename, lineno, err := bi.pcTofileLine(pc)
 	if err != nil {
 		return err
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// the kinds of the entries in .debug_loclists, http://dwarfstd.org/doc/DWARF5.pdf 7.7.3
+const (
+	DW_LLE_end_of_list      = 0x00
+	DW_LLE_base_addressx    = 0x01
+	DW_LLE_startx_endx      = 0x02
+	DW_LLE_startx_length    = 0x03
+	DW_LLE_offset_pair      = 0x04
+	DW_LLE_default_location = 0x05
+	DW_LLE_base_address     = 0x06
+	DW_LLE_start_end        = 0x07
+	DW_LLE_start_length     = 0x08
+)
+
+// openLocationSections reads .debug_loc, .debug_loclists and .debug_addr, all of them are optional.
+func (bi *BI) openLocationSections(elffile *elf.File) error {
+	var err error
+	if bi.LocSection, err = openOptionalSection(elffile, ".debug_loc"); err != nil {
+		return err
+	}
+	if bi.LoclistsSection, err = openOptionalSection(elffile, ".debug_loclists"); err != nil {
+		return err
+	}
+	if bi.AddrSection, err = openOptionalSection(elffile, ".debug_addr"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func openOptionalSection(elffile *elf.File, name string) ([]byte, error) {
+	section := elffile.Section(name)
+	if section == nil {
+		return nil, nil
+	}
+	// please note that Data() returns uncompressed data if compressed
+	return section.Data()
+}
+
+// locationExpression returns the location expression of the variable at pc of the function fn,
+// the location may be a location list whose expression changes over the function.
+func (bi *BI) locationExpression(fv *dwarf.Entry, fn *Function, pc uint64) ([]byte, error) {
+	field := fv.AttrField(dwarf.AttrLocation)
+	if field == nil {
+		return nil, OptimizedOutErr
+	}
+	switch field.Class {
+	case dwarf.ClassExprLoc, dwarf.ClassBlock:
+		if location, ok := field.Val.([]byte); ok {
+			return location, nil
+		}
+	case dwarf.ClassLocListPtr, dwarf.ClassLocList:
+		offset, ok := field.Val.(int64)
+		if !ok || fn == nil || fn.cu == nil {
+			break
+		}
+		cu := fn.cu
+		if !cu.dwarf5 && bi.LocSection != nil {
+			return bi.debugLocExpression(cu, uint64(offset), pc)
+		}
+		if field.Class == dwarf.ClassLocList {
+			// DW_FORM_loclistx is the index into the offsets table after DW_AT_loclists_base
+			pos := uint64(cu.loclistsBase) + uint64(offset)*4
+			if pos+4 > uint64(len(bi.LoclistsSection)) {
+				return nil, fmt.Errorf("invalid location list index %d", offset)
+			}
+			offset = cu.loclistsBase + int64(binary.LittleEndian.Uint32(bi.LoclistsSection[pos:]))
+		}
+		return bi.debugLoclistsExpression(cu, uint64(offset), pc)
+	}
+	return nil, fmt.Errorf("not support the location of variable %s", variableName(fv))
+}
+
+// debugLocExpression finds the expression which covers pc in the location list of .debug_loc at offset.
+func (bi *BI) debugLocExpression(cu *CompileUnit, offset uint64, pc uint64) ([]byte, error) {
+	if offset >= uint64(len(bi.LocSection)) {
+		return nil, fmt.Errorf("invalid .debug_loc offset 0x%x", offset)
+	}
+	base := cu.lowpc
+	buf := bytes.NewBuffer(bi.LocSection[offset:])
+	for {
+		if buf.Len() < 16 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		begin := binary.LittleEndian.Uint64(buf.Next(8))
+		end := binary.LittleEndian.Uint64(buf.Next(8))
+		if begin == 0 && end == 0 {
+			// the end of list, no expression covers pc
+			return nil, OptimizedOutErr
+		}
+		if begin == ^uint64(0) {
+			// the base address selection entry
+			base = end
+			continue
+		}
+		if buf.Len() < 2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		length := int(binary.LittleEndian.Uint16(buf.Next(2)))
+		if buf.Len() < length {
+			return nil, io.ErrUnexpectedEOF
+		}
+		expr := buf.Next(length)
+		if base+begin <= pc && pc < base+end {
+			return expr, nil
+		}
+	}
+}
+
+// debugLoclistsExpression finds the expression which covers pc in the location list of .debug_loclists at offset.
+func (bi *BI) debugLoclistsExpression(cu *CompileUnit, offset uint64, pc uint64) ([]byte, error) {
+	var (
+		kind        byte
+		begin, end  uint64
+		defaultExpr []byte
+		err         error
+	)
+	if offset >= uint64(len(bi.LoclistsSection)) {
+		return nil, fmt.Errorf("invalid .debug_loclists offset 0x%x", offset)
+	}
+	base := cu.lowpc
+	buf := bytes.NewBuffer(bi.LoclistsSection[offset:])
+	for {
+		if kind, err = buf.ReadByte(); err != nil {
+			return nil, err
+		}
+		switch kind {
+		case DW_LLE_end_of_list:
+			if defaultExpr != nil {
+				return defaultExpr, nil
+			}
+			return nil, OptimizedOutErr
+		case DW_LLE_base_addressx:
+			index, _, _ := DecodeULEB128(buf)
+			if base, err = bi.debugAddr(cu, index); err != nil {
+				return nil, err
+			}
+			continue
+		case DW_LLE_base_address:
+			if buf.Len() < 8 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			base = binary.LittleEndian.Uint64(buf.Next(8))
+			continue
+		case DW_LLE_startx_endx:
+			startIndex, _, _ := DecodeULEB128(buf)
+			endIndex, _, _ := DecodeULEB128(buf)
+			if begin, err = bi.debugAddr(cu, startIndex); err != nil {
+				return nil, err
+			}
+			if end, err = bi.debugAddr(cu, endIndex); err != nil {
+				return nil, err
+			}
+		case DW_LLE_startx_length:
+			startIndex, _, _ := DecodeULEB128(buf)
+			length, _, _ := DecodeULEB128(buf)
+			if begin, err = bi.debugAddr(cu, startIndex); err != nil {
+				return nil, err
+			}
+			end = begin + length
+		case DW_LLE_offset_pair:
+			beginOffset, _, _ := DecodeULEB128(buf)
+			endOffset, _, _ := DecodeULEB128(buf)
+			begin, end = base+beginOffset, base+endOffset
+		case DW_LLE_default_location:
+		case DW_LLE_start_end:
+			if buf.Len() < 16 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			begin = binary.LittleEndian.Uint64(buf.Next(8))
+			end = binary.LittleEndian.Uint64(buf.Next(8))
+		case DW_LLE_start_length:
+			if buf.Len() < 8 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			begin = binary.LittleEndian.Uint64(buf.Next(8))
+			length, _, _ := DecodeULEB128(buf)
+			end = begin + length
+		default:
+			return nil, fmt.Errorf("not support location list entry kind 0x%x", kind)
+		}
+
+		length, _, _ := DecodeULEB128(buf)
+		if uint64(buf.Len()) < length {
+			return nil, io.ErrUnexpectedEOF
+		}
+		expr := buf.Next(int(length))
+		if kind == DW_LLE_default_location {
+			defaultExpr = expr
+			continue
+		}
+		if begin <= pc && pc < end {
+			return expr, nil
+		}
+	}
+}
+
+// debugAddr returns the address at index of the unit in .debug_addr.
+func (bi *BI) debugAddr(cu *CompileUnit, index uint64) (uint64, error) {
+	pos := uint64(cu.addrBase) + index*8
+	if pos+8 > uint64(len(bi.AddrSection)) {
+		return 0, fmt.Errorf("invalid .debug_addr index %d", index)
+	}
+	return binary.LittleEndian.Uint64(bi.AddrSection[pos:]), nil
+}
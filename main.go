@@ -21,6 +21,7 @@ var (
 
 func main() {
 	var (
+		args     *godbgArgs
 		filename string
 		err      error
 		p        *prompt.Prompt
@@ -28,45 +29,114 @@ func main() {
 	stdin = os.Stdin
 	stdout = os.Stdout
 	stderr = os.Stderr
-	target = &Target{bi: &BI{}, bp: &BP{}}
+	target = &Target{bi: &BI{}, bp: &BP{}, record: &Recorder{}, skip: newSkipList(), signals: newSignalTable()}
 
-	if err = checkArgs(); err != nil {
+	if args, err = checkArgs(); err != nil {
 		logger.Error(err.Error(), zap.String("stage", "checkArgs"), zap.Strings("args", os.Args))
 		printExecutableProgramHelper()
 		return
 	}
 
-	// step 1, get absolute filename
-	if filename, err = absoluteFilename(); err != nil {
-		logger.Error(err.Error(), zap.String("stage", "absolute"), zap.String("filename", filename))
-		printExecutableProgramHelper()
-		return
-	}
-
-	// step 2, build the filename into executable file
-	if target.execFile, err = build(filename); err != nil {
-		logger.Error(err.Error(), zap.String("stage", "build"), zap.String("filename", filename))
-		printExecutableProgramHelper()
-		return
-	}
-	defer os.Remove(target.execFile)
+	switch args.command {
+	case "attach":
+		// the process keeps running after godbg quits, so its executable isn't removed
+		if err = attachTarget(args.pid); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "attach"), zap.Int("pid", args.pid))
+			printExecutableProgramHelper()
+			return
+		}
+		fmt.Fprintf(stdout, "attach process pid %d\n", args.pid)
+	case "core":
+		// the core is read-only, there is no process to run
+		var execfile, corefile string
+		if execfile, err = absoluteFilename(args.filename); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "absolute"), zap.String("execfile", args.filename))
+			printExecutableProgramHelper()
+			return
+		}
+		if corefile, err = absoluteFilename(args.corefile); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "absolute"), zap.String("corefile", args.corefile))
+			printExecutableProgramHelper()
+			return
+		}
+		if err = checkExecutable(execfile); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "checkExecutable"), zap.String("execfile", execfile))
+			printErr(err)
+			printExecutableProgramHelper()
+			return
+		}
+		if err = coreTarget(execfile, corefile); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "core"), zap.String("execfile", execfile),
+				zap.String("corefile", corefile))
+			printErr(err)
+			printExecutableProgramHelper()
+			return
+		}
+		fmt.Fprintf(stdout, "core of process pid %d, which is terminated by signal %s\n",
+			target.core.pid, target.core.signal)
+	case "exec":
+		target.programOptions = args.program
+		// the prebuilt executable file is debugged as it is
+		if target.execFile, err = absoluteFilename(args.filename); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "absolute"), zap.String("execfile", args.filename))
+			printExecutableProgramHelper()
+			return
+		}
+		// the executable file without DWARF can't be debugged, the source and the variables are unknown
+		if err = checkExecutable(target.execFile); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "checkExecutable"), zap.String("execfile", target.execFile))
+			printErr(err)
+			printExecutableProgramHelper()
+			return
+		}
+		if target.bi, err = analyze(target.execFile); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "analyze"), zap.String("execfile", target.execFile))
+			printExecutableProgramHelper()
+			return
+		}
+		if target.bi.isOptimized() {
+			printWarning(target.execFile + " is built with optimizations, some variables may be optimized out, " +
+				"build it with `-gcflags=\"all=-N -l\"` to debug it completely")
+		}
+		if target.cmd, err = runexec(target.execFile); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "runexec"), zap.String("execfile", target.execFile))
+			printExecutableProgramHelper()
+			return
+		}
+		fmt.Fprintf(stdout, "trace cur process pid %d\n", target.cmd.Process.Pid)
+	default:
+		var pkgDir string
+		target.programOptions = args.program
+		// step 1, 2, build the .go file, the directory or the import path into executable file
+		filename = args.filename
+		if target.execFile, pkgDir, err = build(filename, args.opts); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "build"), zap.String("filename", filename))
+			printExecutableProgramHelper()
+			return
+		}
+		defer os.Remove(target.execFile)
+		// the test binary runs in the directory of its package unless `-wd` is given
+		if target.wd == "" {
+			target.wd = pkgDir
+		}
 
-	// step 3, analyze executable file; The most import places are "_debug_info", "_debug_line"
-	if target.bi, err = analyze(target.execFile); err != nil {
-		logger.Error(err.Error(), zap.String("stage", "analyze"),
-			zap.String("filename", filename), zap.String("execfile", target.execFile))
-		printExecutableProgramHelper()
-		return
-	}
+		// step 3, analyze executable file; The most import places are "_debug_info", "_debug_line"
+		if target.bi, err = analyze(target.execFile); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "analyze"),
+				zap.String("filename", filename), zap.String("execfile", target.execFile))
+			printExecutableProgramHelper()
+			return
+		}
 
-	// step 4, run executable file
-	if target.cmd, err = runexec(target.execFile); err != nil {
-		logger.Error(err.Error(), zap.String("stage", "runexec"),
-			zap.String("filename", filename), zap.String("execfile", target.execFile))
-		printExecutableProgramHelper()
-		return
+		// step 4, run executable file
+		if target.cmd, err = runexec(target.execFile); err != nil {
+			logger.Error(err.Error(), zap.String("stage", "runexec"),
+				zap.String("filename", filename), zap.String("execfile", target.execFile))
+			printExecutableProgramHelper()
+			return
+		}
+		fmt.Fprintf(stdout, "trace cur process pid %d\n", target.cmd.Process.Pid)
 	}
-	fmt.Fprintf(stdout, "trace cur process pid %d\n", target.cmd.Process.Pid)
 
 	// step 5, run prompt. `executor` handle all input
 	p = prompt.New(
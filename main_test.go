@@ -1,18 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
 	"github.com/debugger101/godbg/log"
 	. "github.com/onsi/gomega"
+	"golang.org/x/arch/x86/x86asm"
+	"io/ioutil"
+	"math"
 	"os"
+	"os/exec"
 	"path"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func clear_variable() {
 	target = &Target{
-		bi: &BI{},
-		bp: &BP{},
+		bi:      &BI{},
+		bp:      &BP{},
+		record:  &Recorder{},
+		skip:    newSkipList(),
+		signals: newSignalTable(),
 	}
 	logger = log.Log
 
@@ -35,7 +49,7 @@ func build_run_debug(filename string) (string, error) {
 	filename = path.Join(dir, filename)
 
 	// step 2, build the filename into executable file
-	if execfile, err = build(filename); err != nil {
+	if execfile, _, err = build(filename, buildOptions{}); err != nil {
 		return "", err
 	}
 
@@ -76,7 +90,7 @@ func TestBuild(t *testing.T) {
 	g.Expect(err).Should(BeNil())
 	filename = path.Join(dir, "./test_file/t1.go")
 
-	execfile, err = build(filename)
+	execfile, _, err = build(filename, buildOptions{})
 	g.Expect(err).Should(BeNil())
 	defer os.Remove(execfile)
 
@@ -518,6 +532,11 @@ func TestPrintString(t *testing.T) {
 	g.Expect(outw.String()).Should(ContainSubstring(`hello world`))
 	outw.Reset()
 
+	executor("p godbgvnone")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(ContainSubstring("not find the variable `godbgvnone`"))
+	errw.Reset()
+
 	executor("c")
 	g.Expect(outw.String()).Should(Equal(""))
 	g.Expect(errw.String()).Should(MatchRegexp("Process %d has exited with status 0", pid))
@@ -562,3 +581,1907 @@ func TestPrintString2(t *testing.T) {
 	executor("q")
 	clear_variable()
 }
+
+func TestStepInstruction(t *testing.T) {
+	var (
+		execfile string
+		err      error
+		g        = NewGomegaWithT(t)
+	)
+	outw, errw := make_out_err()
+
+	execfile, err = build_run_debug("./test_file/t3.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	pid := target.cmd.Process.Pid
+
+	executor("b ./test_file/t3.go:10")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t3.go:10 breakpoint successfully"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     10: 	fmt.Printf("%d %d %d %d\n", m, n, i, j)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("si")
+	g.Expect(outw.String()).Should(ContainSubstring("===> t3.go:10"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	// step over `fmt.Printf` instruction by instruction
+	for i := 0; i < 200 && !strings.Contains(outw.String(), "t3.go:11"); i++ {
+		outw.Reset()
+		executor("ni")
+		g.Expect(errw.String()).Should(Equal(""))
+	}
+	g.Expect(outw.String()).Should(ContainSubstring("===> t3.go:11"))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(MatchRegexp("Process %d has exited with status 0", pid))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestNextCount(t *testing.T) {
+	var (
+		execfile string
+		err      error
+		g        = NewGomegaWithT(t)
+	)
+	outw, errw := make_out_err()
+
+	execfile, err = build_run_debug("./test_file/t3.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	pid := target.cmd.Process.Pid
+
+	executor("b ./test_file/t3.go:6")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t3.go:6 breakpoint successfully"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("==>      6: 	m := 0"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("n 3")
+	g.Expect(outw.String()).Should(ContainSubstring("==>      9: 	j := 11"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("n x")
+	g.Expect(errw.String()).Should(ContainSubstring("unsupport cmd `n x`"))
+	errw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(MatchRegexp("Process %d has exited with status 0", pid))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestContinueCount(t *testing.T) {
+	var (
+		execfile string
+		err      error
+		g        = NewGomegaWithT(t)
+	)
+	outw, errw := make_out_err()
+
+	execfile, err = build_run_debug("./test_file/t2.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	pid := target.cmd.Process.Pid
+
+	executor("b ./test_file/t2.go:7")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t2.go:7 breakpoint successfully"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("c 2")
+	g.Expect(outw.String()).Should(ContainSubstring("==>      7: 		fmt.Println(i)"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	// stop early when the process exits
+	executor("c 10")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(MatchRegexp("Process %d has exited with status 0", pid))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestRecordReverse(t *testing.T) {
+	var (
+		execfile string
+		err      error
+		g        = NewGomegaWithT(t)
+	)
+	outw, errw := make_out_err()
+
+	execfile, err = build_run_debug("./test_file/t3.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	pid := target.cmd.Process.Pid
+
+	executor("b ./test_file/t3.go:6")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t3.go:6 breakpoint successfully"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("==>      6: 	m := 0"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("rn")
+	g.Expect(errw.String()).Should(ContainSubstring(NotRecordingErr.Error()))
+	errw.Reset()
+
+	executor("record")
+	g.Expect(outw.String()).Should(ContainSubstring("record started"))
+	outw.Reset()
+
+	executor("n 4")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     10: 	fmt.Printf("%d %d %d %d\n", m, n, i, j)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("rn")
+	g.Expect(outw.String()).Should(ContainSubstring("==>      9: 	j := 11"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("rsi")
+	g.Expect(outw.String()).Should(ContainSubstring("===> t3.go:8"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("rc")
+	g.Expect(outw.String()).Should(ContainSubstring("==>      6: 	m := 0"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("rc")
+	g.Expect(errw.String()).Should(ContainSubstring(NoRecordHistoryErr.Error()))
+	errw.Reset()
+	outw.Reset()
+
+	// execute forward again from the rewound state
+	executor("n")
+	g.Expect(outw.String()).Should(ContainSubstring("==>      7: 	n := 1"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("record stop")
+	g.Expect(outw.String()).Should(ContainSubstring("record stopped"))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(MatchRegexp("Process %d has exited with status 0", pid))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestRecordMemWrites(t *testing.T) {
+	var (
+		regs syscall.PtraceRegs
+		inst x86asm.Inst
+		err  error
+	)
+	g := NewGomegaWithT(t)
+	clear_variable()
+	defer clear_variable()
+	target.process = &fakeProcess{mem: map[uint64]byte{}}
+
+	// rep stosq writes one element by each step
+	inst, err = x86asm.Decode([]byte{0xf3, 0x48, 0xab}, 64)
+	g.Expect(err).Should(BeNil())
+	regs.Rdi, regs.Rcx = 0x2000, 100
+	mems := recordMemWrites(1, &regs, 0x1000, inst)
+	g.Expect(len(mems)).Should(BeNumerically(">", 0))
+	for _, mem := range mems {
+		g.Expect(mem.addr).Should(Equal(uint64(0x2000)))
+		g.Expect(len(mem.original)).Should(Equal(8))
+	}
+	g.Expect(usesFPRegisters(inst)).Should(BeFalse())
+
+	// movups xmm0, xmm1 changes the registers which aren't in PtraceRegs
+	inst, err = x86asm.Decode([]byte{0x0f, 0x10, 0xc1}, 64)
+	g.Expect(err).Should(BeNil())
+	g.Expect(usesFPRegisters(inst)).Should(BeTrue())
+}
+
+func TestStepSkip(t *testing.T) {
+	var (
+		execfile string
+		err      error
+		g        = NewGomegaWithT(t)
+	)
+	outw, errw := make_out_err()
+
+	execfile, err = build_run_debug("./test_file/t4.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	pid := target.cmd.Process.Pid
+
+	executor("skip")
+	g.Expect(outw.String()).Should(ContainSubstring("1 . runtime.*"))
+	g.Expect(outw.String()).Should(ContainSubstring("<stdlib>"))
+	outw.Reset()
+
+	executor("skip add main.pppp2")
+	g.Expect(outw.String()).Should(ContainSubstring("skip add `main.pppp2` successfully"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("b ./test_file/t4.go:10")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t4.go:10 breakpoint successfully"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     10: 	fmt.Printf("n = %d\n", n)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	// `fmt.Printf` is in the standard library
+	executor("s")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     11: 	mstr := pppp2(m)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("s")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     12: 	fmt.Println(mstr)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("skip del main.pppp2")
+	g.Expect(outw.String()).Should(ContainSubstring("skip del `main.pppp2` successfully"))
+	outw.Reset()
+
+	executor("skip del main.pppp2")
+	g.Expect(errw.String()).Should(ContainSubstring("can't find skip pattern `main.pppp2`"))
+	errw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(MatchRegexp("Process %d has exited with status 0", pid))
+
+	executor("q")
+	clear_variable()
+}
+
+// runtime.morestack is called when the stack grows
+func TestNextStepMorestack(t *testing.T) {
+	var (
+		execfile string
+		err      error
+		g        = NewGomegaWithT(t)
+	)
+	outw, errw := make_out_err()
+
+	execfile, err = build_run_debug("./test_file/t7.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	pid := target.cmd.Process.Pid
+
+	executor("b ./test_file/t7.go:15")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t7.go:15 breakpoint successfully"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     15: 	s := grow(50)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("n")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     16: 	fmt.Println(s)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("n")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     17: 	s = grow(60)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("n")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     18: 	fmt.Println(s)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(MatchRegexp("Process %d has exited with status 0", pid))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestStepMorestack(t *testing.T) {
+	var (
+		execfile string
+		err      error
+		g        = NewGomegaWithT(t)
+	)
+	outw, errw := make_out_err()
+
+	execfile, err = build_run_debug("./test_file/t7.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+
+	executor("b ./test_file/t7.go:17")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t7.go:17 breakpoint successfully"))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     17: 	s = grow(60)`))
+	outw.Reset()
+
+	// step into grow, stop at the end of the prologue which may call runtime.morestack
+	executor("s")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>      5: func grow(n int) int {`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("bt 2")
+	g.Expect(outw.String()).Should(MatchRegexp(`\*#0 .*test_file/t7.go:5 main.grow\n #1 .*test_file/t7.go:17 main.main\n`))
+	g.Expect(errw.String()).Should(Equal(""))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestPrologueEnd(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	dir, err = os.Getwd()
+	g.Expect(err).Should(BeNil())
+	execfile, _, err = build(path.Join(dir, "./test_file/t7.go"), buildOptions{})
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+	checked := 0
+	for _, f := range target.bi.Functions {
+		if !strings.HasPrefix(f.name, "main.") {
+			continue
+		}
+		// the lowest address which is marked in the line tables of all files
+		var (
+			want  uint64
+			found bool
+		)
+		for _, lines := range target.bi.Sources {
+			for _, entries := range lines {
+				for _, entry := range entries {
+					if entry.PrologueEnd && f.contains(entry.Address) && (!found || entry.Address < want) {
+						want, found = entry.Address, true
+					}
+				}
+			}
+		}
+		end, ok := target.bi.prologueEnd(f)
+		g.Expect(ok).Should(Equal(found), f.name)
+		g.Expect(end).Should(Equal(want), f.name)
+		checked++
+	}
+	g.Expect(checked).Should(BeNumerically(">=", 2))
+	clear_variable()
+}
+
+func TestFrameInstructions(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// the prologue of a frame-pointer function which is compiled by gcc
+	cie := &CommonInformationEntry{
+		code_alignment_factor: 1,
+		data_alignment_factor: -8,
+		initial_instructions: []byte{
+			DW_CFA_def_cfa, 7, 8, // cfa = rsp + 8
+			DW_CFA_offset | 16, 1, // ra at cfa - 8
+		},
+	}
+	fdeInstructions := []byte{
+		DW_CFA_advance_loc | 1,
+		DW_CFA_def_cfa_offset, 16,
+		DW_CFA_offset | 6, 2, // rbp at cfa - 16
+		DW_CFA_advance_loc | 3,
+		DW_CFA_def_cfa_register, 6,
+		DW_CFA_remember_state,
+		DW_CFA_advance_loc1, 0x10,
+		DW_CFA_def_cfa, 7, 8,
+		DW_CFA_restore | 6,
+		DW_CFA_advance_loc2, 0x01, 0x00,
+		DW_CFA_restore_state,
+		DW_CFA_advance_loc4, 0x01, 0x00, 0x00, 0x00,
+		DW_CFA_val_offset, 3, 3,
+		DW_CFA_nop,
+	}
+
+	exec := func(pc uint64) *Frame {
+		frame := &Frame{cie: cie, cfa: &DWRule{}, regsRule: make(map[uint64]DWRule)}
+		g.Expect(execCIEInstructions(frame, bytes.NewBuffer(cie.initial_instructions))).Should(BeNil())
+		frame.loc = 0x1000
+		frame.address = pc
+		g.Expect(execFDEInstructions(frame, bytes.NewBuffer(fdeInstructions))).Should(BeNil())
+		return frame
+	}
+
+	frame := exec(0x1000)
+	g.Expect(*frame.cfa).Should(Equal(DWRule{reg: 7, offset: 8, rule: RuleCFA}))
+	g.Expect(frame.regsRule[16]).Should(Equal(DWRule{offset: -8, rule: RuleOffset}))
+
+	frame = exec(0x1001)
+	g.Expect(*frame.cfa).Should(Equal(DWRule{reg: 7, offset: 16, rule: RuleCFA}))
+	g.Expect(frame.regsRule[6]).Should(Equal(DWRule{offset: -16, rule: RuleOffset}))
+
+	frame = exec(0x1004)
+	g.Expect(*frame.cfa).Should(Equal(DWRule{reg: 6, offset: 16, rule: RuleCFA}))
+
+	frame = exec(0x1014)
+	g.Expect(*frame.cfa).Should(Equal(DWRule{reg: 7, offset: 8, rule: RuleCFA}))
+	g.Expect(frame.regsRule).ShouldNot(HaveKey(uint64(6)))
+
+	frame = exec(0x1015)
+	g.Expect(*frame.cfa).Should(Equal(DWRule{reg: 6, offset: 16, rule: RuleCFA}))
+	g.Expect(frame.regsRule[6]).Should(Equal(DWRule{offset: -16, rule: RuleOffset}))
+
+	frame = exec(0x1016)
+	g.Expect(frame.regsRule[3]).Should(Equal(DWRule{offset: -24, rule: RuleValOffset}))
+
+	// the truncated operands of a corrupted expression are errors
+	regs := make([]uint64, dwarfRegPc+1)
+	for _, expr := range [][]byte{
+		{DW_OP_addr, 1, 2},
+		{DW_OP_const1u},
+		{DW_OP_const2u, 1},
+		{DW_OP_const4s, 1, 2, 3},
+		{DW_OP_constu, 0x80},
+		{DW_OP_bregx, 6},
+		{DW_OP_breg0 + 7},
+		{DW_OP_lit0, DW_OP_pick},
+		{DW_OP_lit0, DW_OP_deref_size},
+		{DW_OP_skip, 1},
+		{DW_OP_lit0, DW_OP_bra},
+	} {
+		_, err := execDwarfExpression(0, expr, regs, 0)
+		g.Expect(err).ShouldNot(BeNil())
+	}
+
+	// so are the truncated operands of the instructions, and changing the register or the offset of an expression cfa
+	for _, instructions := range [][]byte{
+		{DW_CFA_offset | 6},
+		{DW_CFA_offset_extended, 6},
+		{DW_CFA_offset_extended_sf, 6, 0x80},
+		{DW_CFA_register, 6},
+		{DW_CFA_def_cfa, 7},
+		{DW_CFA_def_cfa_sf},
+		{DW_CFA_def_cfa_offset},
+		{DW_CFA_val_offset_sf, 3},
+		{DW_CFA_GNU_args_size, 0x80},
+		{DW_CFA_def_cfa_expression, 1, DW_OP_lit0, DW_CFA_def_cfa_offset, 16},
+		{DW_CFA_def_cfa_expression, 1, DW_OP_lit0, DW_CFA_def_cfa_register, 6},
+	} {
+		frame := &Frame{cie: cie, cfa: &DWRule{}, regsRule: make(map[uint64]DWRule)}
+		g.Expect(execCIEInstructions(frame, bytes.NewBuffer(instructions))).ShouldNot(BeNil())
+	}
+}
+
+func TestEHFrame(t *testing.T) {
+	var (
+		filename string
+		execfile string
+		elffile  *elf.File
+		symbols  []elf.Symbol
+		frame    *Frame
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	dir, err := os.Getwd()
+	g.Expect(err).Should(BeNil())
+	filename = path.Join(dir, "./test_file/t8.go")
+
+	execfile, _, err = build(filename, buildOptions{})
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+
+	elffile, err = elf.Open(execfile)
+	g.Expect(err).Should(BeNil())
+	defer elffile.Close()
+	symbols, err = elffile.Symbols()
+	g.Expect(err).Should(BeNil())
+
+	found := false
+	for _, sym := range symbols {
+		if sym.Name != "add" {
+			continue
+		}
+		found = true
+		// the c function is only described by .eh_frame
+		frame, err = target.bi.execFrameInstructions(sym.Value)
+		g.Expect(err).Should(BeNil())
+		g.Expect(frame.cie.augmentation).Should(HavePrefix("z"))
+		g.Expect(*frame.cfa).Should(Equal(DWRule{reg: 7, offset: 8, rule: RuleCFA}))
+	}
+	g.Expect(found).Should(BeTrue())
+
+	clear_variable()
+}
+
+func TestEHFrameAugmentation(t *testing.T) {
+	var (
+		cie   *CommonInformationEntry
+		fde   *FrameDescriptionEntry
+		frame *Frame
+		err   error
+	)
+	g := NewGomegaWithT(t)
+	sdata4 := func(v int32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(v))
+		return b
+	}
+
+	// the cie of a c++ function, the personality routine is an indirect pcrel pointer
+	data := []byte{1, 'z', 'P', 'L', 'R', 0, 1, 0x78, 16, 7, 0x9b}
+	data = append(data, sdata4(0x2000)...)
+	data = append(data, 0x1b, 0x1b, DW_CFA_def_cfa, 7, 8)
+	cie, err = parseEHFrameCIE(uint32(len(data)+4), data)
+	g.Expect(err).Should(BeNil())
+	g.Expect(cie.ptrEncoding).Should(Equal(byte(0x1b)))
+	g.Expect(cie.lsdaEncoding).Should(Equal(byte(0x1b)))
+	g.Expect(cie.initial_instructions).Should(Equal([]byte{DW_CFA_def_cfa, 7, 8}))
+
+	// the fde at 0x10000 covers [0x1000, 0x1100), DW_CFA_set_loc moves to 0x1010 by a pcrel address
+	data = append(sdata4(0x1000-0x10000), sdata4(0x100)...)
+	data = append(data, 0, DW_CFA_set_loc)
+	data = append(data, sdata4(0x1010-0x1000a)...)
+	data = append(data, DW_CFA_def_cfa_offset, 16)
+	fde, err = parseEHFrameFDE(uint32(len(data)+4), data, cie, 0x10000)
+	g.Expect(err).Should(BeNil())
+	g.Expect(fde.begin).Should(Equal(uint64(0x1000)))
+	g.Expect(fde.size).Should(Equal(uint64(0x100)))
+
+	bi := &BI{FramesInformation: []*VirtualUnwindFrameInformation{{CIE: cie}, {FDE: fde}}}
+	bi.buildFDEIndex()
+	frame, err = bi.execFrameInstructions(0x100f)
+	g.Expect(err).Should(BeNil())
+	g.Expect(*frame.cfa).Should(Equal(DWRule{reg: 7, offset: 8, rule: RuleCFA}))
+	frame, err = bi.execFrameInstructions(0x1010)
+	g.Expect(err).Should(BeNil())
+	g.Expect(*frame.cfa).Should(Equal(DWRule{reg: 7, offset: 16, rule: RuleCFA}))
+}
+
+func TestFDEIndex(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	fdes := []*FrameDescriptionEntry{
+		{begin: 0x3000, size: 0x100},
+		{begin: 0x1000, size: 0x100},
+		{begin: 0x2000, size: 0x80},
+		{begin: 0x2040, size: 0x100}, // overlaps the former one, its tail is kept
+		{begin: 0x1100, size: 0},
+		{begin: 0x2000, size: 0x80},  // the same as an earlier one
+		{begin: 0x3010, size: 0x10},  // covered by an earlier one
+		{begin: 0x1000, size: 0x200}, // the same begin as an earlier one, its tail is kept
+	}
+	bi := &BI{}
+	for _, fde := range fdes {
+		bi.FramesInformation = append(bi.FramesInformation, &VirtualUnwindFrameInformation{FDE: fde})
+	}
+	bi.buildFDEIndex()
+	g.Expect(bi.FDEs).Should(HaveLen(5))
+
+	g.Expect(bi.findFDE(0xfff)).Should(BeNil())
+	g.Expect(bi.findFDE(0x1000)).Should(BeIdenticalTo(fdes[1]))
+	g.Expect(bi.findFDE(0x10ff)).Should(BeIdenticalTo(fdes[1]))
+	g.Expect(bi.findFDE(0x1100)).Should(BeIdenticalTo(fdes[7]))
+	g.Expect(bi.findFDE(0x1200)).Should(BeNil())
+	g.Expect(bi.findFDE(0x2050)).Should(BeIdenticalTo(fdes[2]))
+	g.Expect(bi.findFDE(0x2090)).Should(BeIdenticalTo(fdes[3]))
+	g.Expect(bi.findFDE(0x2140)).Should(BeNil())
+	g.Expect(bi.findFDE(0x3018)).Should(BeIdenticalTo(fdes[0]))
+	g.Expect(bi.findFDE(0x3080)).Should(BeIdenticalTo(fdes[0]))
+	g.Expect(bi.findFDE(0x3100)).Should(BeNil())
+}
+
+func TestFrameSelect(t *testing.T) {
+	var (
+		execfile string
+		err      error
+		g        = NewGomegaWithT(t)
+	)
+	outw, errw := make_out_err()
+
+	execfile, err = build_run_debug("./test_file/t4.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	pid := target.cmd.Process.Pid
+
+	executor("b ./test_file/t4.go:6")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t4.go:6 breakpoint successfully"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>      6: 	return fmt.Sprintf("m = %d", m)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("up")
+	g.Expect(outw.String()).Should(ContainSubstring(`#1 `))
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     11: 	mstr := pppp2(m)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("up")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     16: 	pppp1(200, 300)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("l")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     16: 	pppp1(200, 300)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("down 2")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>      6: 	return fmt.Sprintf("m = %d", m)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("down")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(ContainSubstring(InitialFrameErr.Error()))
+	errw.Reset()
+
+	executor("frame 1")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>     11: 	mstr := pppp2(m)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("frame 100")
+	g.Expect(errw.String()).Should(ContainSubstring(OutermostFrameErr.Error()))
+	errw.Reset()
+	outw.Reset()
+
+	// the innermost frame is selected again after the process is resumed
+	executor("si")
+	g.Expect(errw.String()).Should(Equal(""))
+	g.Expect(target.frame).Should(Equal(0))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(MatchRegexp("Process %d has exited with status 0", pid))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestBacktraceOptions(t *testing.T) {
+	var (
+		execfile string
+		err      error
+		g        = NewGomegaWithT(t)
+	)
+	outw, errw := make_out_err()
+
+	execfile, err = build_run_debug("./test_file/t4.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	pid := target.cmd.Process.Pid
+
+	executor("b ./test_file/t4.go:6")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t4.go:6 breakpoint successfully"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>      6: 	return fmt.Sprintf("m = %d", m)`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("bt 2")
+	g.Expect(outw.String()).Should(MatchRegexp(`\*#0  pc=0x[0-9a-f]+ +sp=0x[0-9a-f]+ +cfa=0x[0-9a-f]+ +\S*test_file/t4.go:6 `))
+	g.Expect(outw.String()).Should(ContainSubstring(` #1  `))
+	g.Expect(outw.String()).ShouldNot(ContainSubstring(`#2`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("bt -args -full")
+	g.Expect(outw.String()).Should(ContainSubstring(`test_file/t4.go:6 main.pppp2(m = 300, ~r0 = `))
+	g.Expect(outw.String()).Should(ContainSubstring(`test_file/t4.go:11 main.pppp1(n = 200, m = 300)` + "\n        mstr = "))
+	g.Expect(outw.String()).Should(ContainSubstring(`test_file/t4.go:16 main.main()` + "\n"))
+	g.Expect(outw.String()).Should(MatchRegexp(`/runtime/proc.go:\d+ runtime.main\(\) \[runtime\]`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("bt -x")
+	g.Expect(errw.String()).Should(ContainSubstring("unsupport cmd `bt -x`"))
+	errw.Reset()
+
+	executor("bt 2 3")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(ContainSubstring("unsupport cmd `bt 2 3`"))
+	errw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(Equal(""))
+	g.Expect(errw.String()).Should(MatchRegexp("Process %d has exited with status 0", pid))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestInlinedCall(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	outw, errw := make_out_err()
+
+	// `build` disables inlining by `-l`, so t9.go is built with `-N` only
+	dir, err = os.Getwd()
+	g.Expect(err).Should(BeNil())
+	execfile = path.Join(os.TempDir(), "__t9.go__")
+	err = exec.Command("go", "build", "-gcflags", "all=-N", "-o", execfile, path.Join(dir, "./test_file/t9.go")).Run()
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(len(target.bi.InlinedCalls)).Should(BeNumerically(">", 0))
+	target.cmd, err = runexec(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(os.Setenv("GODBG_TEST", "true")).Should(BeNil())
+	pid := target.cmd.Process.Pid
+
+	executor("b ./test_file/t9.go:10")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t9.go:10 breakpoint successfully"))
+	outw.Reset()
+
+	// add is inlined into main twice, every copy gets a breakpoint
+	executor("b ./test_file/t9.go:6")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t9.go:6 breakpoint successfully"))
+	outw.Reset()
+	executor("bl")
+	g.Expect(strings.Count(outw.String(), "./test_file/t9.go:6")).Should(Equal(1))
+	g.Expect(outw.String()).Should(MatchRegexp(`2 \. \./test_file/t9.go:6, pc 0x[0-9a-f]+, 0x[0-9a-f]+\n`))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("==>     10: \treturn add(a, a)"))
+	outw.Reset()
+	executor("bt")
+	g.Expect(outw.String()).Should(MatchRegexp(`\*#0 .*test_file/t9.go:10 main.twice \[inlined\]\n #1 .*test_file/t9.go:14 `))
+	outw.Reset()
+
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("==>      6: \treturn a + b"))
+	outw.Reset()
+	executor("bt")
+	g.Expect(outw.String()).Should(MatchRegexp(`\*#0 .*test_file/t9.go:6 main.add \[inlined\]\n #1 .*test_file/t9.go:16 `))
+	outw.Reset()
+	executor("up")
+	g.Expect(outw.String()).Should(ContainSubstring("==>     16: \tfmt.Println(add(s, 1))"))
+	outw.Reset()
+
+	executor("c")
+	executor("bt")
+	g.Expect(outw.String()).Should(MatchRegexp(`\*#0 .*test_file/t9.go:6 main.add \[inlined\]\n #1 .*test_file/t9.go:17 `))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	// every copy is cleared with its breakpoint
+	executor("bc 2")
+	g.Expect(outw.String()).Should(ContainSubstring("clear breakpoint 2 successfully"))
+	outw.Reset()
+	executor("bl")
+	g.Expect(outw.String()).Should(MatchRegexp(`^1 \. \./test_file/t9.go:10, pc 0x[0-9a-f]+\n$`))
+	outw.Reset()
+	executor("c")
+	g.Expect(errw.String()).Should(MatchRegexp("Process %d has exited with status 0", pid))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestOptimizedBuild(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	outw, errw := make_out_err()
+
+	dir, err = os.Getwd()
+	g.Expect(err).Should(BeNil())
+	execfile, _, err = build(path.Join(dir, "./test_file/t10.go"), buildOptions{optimized: true})
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+	// double is inlined into sum without `-l`
+	g.Expect(len(target.bi.InlinedCalls)).Should(BeNumerically(">", 0))
+	target.cmd, err = runexec(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(os.Setenv("GODBG_TEST", "true")).Should(BeNil())
+
+	executor("b ./test_file/t10.go:15")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t10.go:15 breakpoint successfully"))
+	outw.Reset()
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("==>     15: \treturn total"))
+	outw.Reset()
+
+	// the variables are in location lists
+	executor("args")
+	g.Expect(outw.String()).Should(ContainSubstring("n = 10\n~r0 = <optimized out>\n"))
+	outw.Reset()
+	executor("p total")
+	g.Expect(outw.String()).Should(Equal("90\n"))
+	outw.Reset()
+	executor("p ~r0")
+	g.Expect(errw.String()).Should(Equal("optimized out\n"))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestLocationPieces(t *testing.T) {
+	var (
+		pieces []locationPiece
+		val    []byte
+		err    error
+	)
+	g := NewGomegaWithT(t)
+	sf := &Stackframe{regs: make([]uint64, dwarfRegPc+1)}
+	sf.regs[0] = 0x1234
+	sf.regs[3] = 5
+
+	// a string in registers: DW_OP_reg0 DW_OP_piece 8 DW_OP_reg3 DW_OP_piece 8
+	pieces, err = locationPieces(0, []byte{DW_OP_reg0, DW_OP_piece, 8, DW_OP_reg0 + 3, DW_OP_piece, 8}, sf)
+	g.Expect(err).Should(BeNil())
+	g.Expect(len(pieces)).Should(Equal(2))
+	g.Expect(pieces[1].kind).Should(Equal(pieceRegister))
+	g.Expect(pieces[1].reg).Should(Equal(uint64(3)))
+	val, err = readPieces(0, pieces, sf, 16)
+	g.Expect(err).Should(BeNil())
+	g.Expect(readUnsigned(val[:8])).Should(Equal(uint64(0x1234)))
+	g.Expect(readUnsigned(val[8:])).Should(Equal(uint64(5)))
+
+	// a constant: DW_OP_lit7 DW_OP_stack_value
+	pieces, err = locationPieces(0, []byte{DW_OP_lit0 + 7, DW_OP_stack_value}, sf)
+	g.Expect(err).Should(BeNil())
+	val, err = readPieces(0, pieces, sf, 8)
+	g.Expect(err).Should(BeNil())
+	g.Expect(signExtend(val)).Should(Equal(int64(7)))
+
+	// the empty location and the empty piece are optimized out
+	pieces, err = locationPieces(0, []byte{}, sf)
+	g.Expect(err).Should(BeNil())
+	_, err = readPieces(0, pieces, sf, 8)
+	g.Expect(err).Should(Equal(OptimizedOutErr))
+	pieces, err = locationPieces(0, []byte{DW_OP_reg0, DW_OP_piece, 8, DW_OP_piece, 8}, sf)
+	g.Expect(err).Should(BeNil())
+	_, err = readPieces(0, pieces, sf, 16)
+	g.Expect(err).Should(Equal(OptimizedOutErr))
+
+	// a float in xmm1 is read from the fp registers
+	fake := &fakeProcess{mem: map[uint64]byte{}, fpregs: make([]byte, fpregsSize)}
+	binary.LittleEndian.PutUint64(fake.fpregs[fpregsXmmOffset+16:], math.Float64bits(2.5))
+	target.process = fake
+	defer func() { target.process = nil }()
+	pieces, err = locationPieces(0, []byte{DW_OP_regx, dwarfRegXmm0 + 1}, sf)
+	g.Expect(err).Should(BeNil())
+	val, err = readPieces(0, pieces, sf, 8)
+	g.Expect(err).Should(BeNil())
+	g.Expect(math.Float64frombits(readUnsigned(val))).Should(Equal(2.5))
+
+	// only rsp, rbp and pc are restored in a caller frame
+	sf.outer = true
+	sf.regs[dwarfRegRsp] = 0x7000
+	_, err = readPieces(0, []locationPiece{{kind: pieceRegister, reg: 0}}, sf, 8)
+	g.Expect(err).Should(Equal(OptimizedOutErr))
+	_, err = readPieces(0, []locationPiece{{kind: pieceRegister, reg: dwarfRegXmm0}}, sf, 8)
+	g.Expect(err).Should(Equal(OptimizedOutErr))
+	val, err = readPieces(0, []locationPiece{{kind: pieceRegister, reg: dwarfRegRsp}}, sf, 8)
+	g.Expect(err).Should(BeNil())
+	g.Expect(readUnsigned(val)).Should(Equal(uint64(0x7000)))
+}
+
+func TestLocationList(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		fn       *Function
+		expr     []byte
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	dir, err = os.Getwd()
+	g.Expect(err).Should(BeNil())
+	execfile, _, err = build(path.Join(dir, "./test_file/t10.go"), buildOptions{optimized: true})
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+	for _, f := range target.bi.Functions {
+		if f.name == "main.sum" {
+			fn = f
+		}
+	}
+	g.Expect(fn).ShouldNot(BeNil())
+	var n *dwarf.Entry
+	for _, fv := range fn.variables {
+		if variableName(fv) == "n" {
+			n = fv
+		}
+	}
+	g.Expect(n).ShouldNot(BeNil())
+	g.Expect(n.AttrField(dwarf.AttrLocation).Class).Should(Equal(dwarf.ClassLocListPtr))
+
+	// the first argument is passed by rax with the register abi
+	expr, err = target.bi.locationExpression(n, fn, fn.lowpc)
+	g.Expect(err).Should(BeNil())
+	g.Expect(expr).Should(Equal([]byte{DW_OP_reg0}))
+	_, err = target.bi.locationExpression(n, fn, 0)
+	g.Expect(err).Should(Equal(OptimizedOutErr))
+
+	// .debug_loc of DWARF 4: a base address selection, [0x10, 0x20) in rbx, the end of list
+	loc := new(bytes.Buffer)
+	for _, v := range []uint64{^uint64(0), 0x1000, 0x10, 0x20} {
+		g.Expect(binary.Write(loc, binary.LittleEndian, v)).Should(BeNil())
+	}
+	loc.Write([]byte{1, 0, DW_OP_reg0 + 3})
+	loc.Write(make([]byte, 16))
+	bi := &BI{LocSection: loc.Bytes()}
+	cu := &CompileUnit{}
+	expr, err = bi.debugLocExpression(cu, 0, 0x1018)
+	g.Expect(err).Should(BeNil())
+	g.Expect(expr).Should(Equal([]byte{DW_OP_reg0 + 3}))
+	_, err = bi.debugLocExpression(cu, 0, 0x18)
+	g.Expect(err).Should(Equal(OptimizedOutErr))
+	clear_variable()
+}
+
+func TestFunctionRanges(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		fn       *Function
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	dir, err = os.Getwd()
+	g.Expect(err).Should(BeNil())
+	execfile, _, err = build(path.Join(dir, "./test_file/t4.go"), buildOptions{})
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+	for _, f := range target.bi.Functions {
+		if f.name == "main.pppp2" {
+			fn = f
+		}
+	}
+	g.Expect(fn).ShouldNot(BeNil())
+	// DW_AT_high_pc may be an offset from DW_AT_low_pc
+	g.Expect(fn.highpc).Should(BeNumerically(">", fn.lowpc))
+	f, err := target.bi.findFunctionIncludePc(fn.highpc - 1)
+	g.Expect(err).Should(BeNil())
+	g.Expect(f).Should(Equal(fn))
+	g.Expect(f.cu.contains(fn.lowpc)).Should(BeTrue())
+
+	// the code of the function is non-contiguous with DW_AT_ranges
+	f = &Function{lowpc: 0x100, highpc: 0x180, ranges: [][2]uint64{{0x100, 0x180}, {0x200, 0x220}}}
+	g.Expect(f.contains(0x210)).Should(BeTrue())
+	g.Expect(f.contains(0x180)).Should(BeFalse())
+	r, ok := f.rangeIncludePc(0x210)
+	g.Expect(ok).Should(BeTrue())
+	g.Expect(r).Should(Equal([2]uint64{0x200, 0x220}))
+	clear_variable()
+}
+
+func TestAttach(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	outw, errw := make_out_err()
+
+	dir, err = os.Getwd()
+	g.Expect(err).Should(BeNil())
+	execfile, _, err = build(path.Join(dir, "./test_file/t11.go"), buildOptions{})
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+
+	// the process is started without the debugger, it isn't waited until the end,
+	// because the waiting thread would take the ptrace stops
+	cmd := exec.Command(execfile)
+	g.Expect(cmd.Start()).Should(BeNil())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	pid := cmd.Process.Pid
+
+	g.Expect(attachTarget(pid)).Should(BeNil())
+	g.Expect(len(target.threads)).Should(BeNumerically(">", 1))
+	g.Expect(os.Setenv("GODBG_TEST", "true")).Should(BeNil())
+
+	executor("b ./test_file/t11.go:10")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t11.go:10 breakpoint successfully"))
+	outw.Reset()
+
+	executor("c 3")
+	g.Expect(outw.String()).Should(ContainSubstring("==>     10: \treturn i * 2"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("bt 2")
+	g.Expect(outw.String()).Should(MatchRegexp(`\*#0 .*test_file/t11.go:10 main.work\n #1 .*test_file/t11.go:23 main.main\n`))
+	outw.Reset()
+
+	executor("n")
+	g.Expect(outw.String()).Should(ContainSubstring("==>     23: \t\tif work(i) < 0 {"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	executor("detach")
+	g.Expect(outw.String()).Should(ContainSubstring(fmt.Sprintf("detach process %d", pid)))
+	g.Expect(errw.String()).Should(Equal(""))
+
+	// the process keeps running without the breakpoints
+	time.Sleep(300 * time.Millisecond)
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	g.Expect(err).Should(BeNil())
+	g.Expect(string(stat)).Should(MatchRegexp(`^\d+ \(\S+\) [RS] `))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestExec(t *testing.T) {
+	var (
+		dir string
+		bi  *BI
+		err error
+	)
+	g := NewGomegaWithT(t)
+	dir, err = os.Getwd()
+	g.Expect(err).Should(BeNil())
+	filename := path.Join(dir, "./test_file/t4.go")
+	execfile := path.Join(os.TempDir(), "__t4_exec__")
+	defer os.Remove(execfile)
+
+	args := os.Args
+	defer func() {
+		os.Args = args
+	}()
+	os.Args = []string{"godbg", "exec", execfile}
+	godbgArgs, err := checkArgs()
+	g.Expect(err).Should(BeNil())
+	g.Expect(godbgArgs.command).Should(Equal("exec"))
+	g.Expect(godbgArgs.filename).Should(Equal(execfile))
+
+	// built by `go build` directly, with optimizations
+	g.Expect(exec.Command("go", "build", "-o", execfile, filename).Run()).Should(BeNil())
+	g.Expect(checkExecutable(execfile)).Should(BeNil())
+	bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(bi.isOptimized()).Should(BeTrue())
+
+	g.Expect(exec.Command("go", "build", "-gcflags", "all=-N -l", "-o", execfile, filename).Run()).Should(BeNil())
+	bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(bi.isOptimized()).Should(BeFalse())
+
+	// without DWARF
+	g.Expect(exec.Command("go", "build", "-ldflags", "-w", "-o", execfile, filename).Run()).Should(BeNil())
+	err = checkExecutable(execfile)
+	g.Expect(err).ShouldNot(BeNil())
+	g.Expect(err.Error()).Should(ContainSubstring("has no DWARF"))
+}
+
+func TestBuildPackage(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	outw, errw := make_out_err()
+
+	opts := buildOptions{tags: "godbg", mod: "mod", race: true, gcflags: "-m", ldflags: "-X main.v=1"}
+	g.Expect(opts.goBuildArgs("/tmp/x", "./cmd")).Should(Equal([]string{"build", "-tags", "godbg", "-mod", "mod", "-race",
+		"-gcflags", "all=-N -l", "-gcflags", "-N -l -m", "-ldflags", "-X main.v=1", "-o", "/tmp/x", "./cmd"}))
+	opts = buildOptions{gcflags: "all=-m"}
+	g.Expect(opts.goBuildArgs("/tmp/x", "./cmd")).Should(Equal([]string{"build",
+		"-gcflags", "all=-N -l", "-gcflags", "all=-N -l -m", "-o", "/tmp/x", "./cmd"}))
+
+	// the import path is built in the root of module
+	dir, err = os.Getwd()
+	g.Expect(err).Should(BeNil())
+	execfile, _, err = build("github.com/debugger101/godbg/test_file/t12", buildOptions{})
+	g.Expect(err).Should(BeNil())
+	g.Expect(execfile).Should(HaveSuffix("__t12__"))
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(target.bi.Sources).Should(HaveKey(path.Join(dir, "test_file/t12/notag.go")))
+
+	// the directory of the main package which has multiple files
+	execfile, _, err = build("./test_file/t12", buildOptions{tags: "godbg"})
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(target.bi.Sources).Should(HaveKey(path.Join(dir, "test_file/t12/tag.go")))
+	g.Expect(target.bi.Sources).ShouldNot(HaveKey(path.Join(dir, "test_file/t12/notag.go")))
+
+	target.cmd, err = runexec(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(os.Setenv("GODBG_TEST", "true")).Should(BeNil())
+
+	executor("b ./test_file/t12/double.go:4")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t12/double.go:4 breakpoint successfully"))
+	outw.Reset()
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("==>      4: \treturn n * 2"))
+	outw.Reset()
+	executor("args")
+	g.Expect(outw.String()).Should(ContainSubstring("n = 21"))
+	g.Expect(errw.String()).Should(Equal(""))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestBuildTest(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	outw, errw := make_out_err()
+
+	opts := buildOptions{test: true}
+	g.Expect(opts.goBuildArgs("/tmp/x", "./calc")).Should(Equal([]string{"test", "-c",
+		"-gcflags", "all=-N -l", "-o", "/tmp/x", "./calc"}))
+
+	dir, err = os.Getwd()
+	g.Expect(err).Should(BeNil())
+	execfile, target.wd, err = build("./test_file/t13", opts)
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	g.Expect(execfile).Should(HaveSuffix("__t13.test__"))
+	g.Expect(target.wd).Should(Equal(path.Join(dir, "test_file/t13")))
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+
+	// only TestData runs, so the breakpoint in Add is hit with its arguments
+	target.args = []string{"-test.run", "TestData", "-test.v"}
+	target.cmd, err = runexec(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(os.Setenv("GODBG_TEST", "true")).Should(BeNil())
+
+	executor("b ./test_file/t13/calc.go:5")
+	g.Expect(outw.String()).Should(ContainSubstring("godbg add ./test_file/t13/calc.go:5 breakpoint successfully"))
+	outw.Reset()
+	executor("c")
+	g.Expect(errw.String()).Should(Equal(""))
+	g.Expect(outw.String()).Should(ContainSubstring("==>      5: \treturn sum"))
+	outw.Reset()
+	executor("args")
+	g.Expect(outw.String()).Should(ContainSubstring("a = 3"))
+	outw.Reset()
+	executor("bt")
+	g.Expect(outw.String()).Should(ContainSubstring("TestData"))
+	g.Expect(errw.String()).Should(Equal(""))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestProgramOptions(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		out      []byte
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	outw, errw := make_out_err()
+
+	dir, err = ioutil.TempDir("", "godbg")
+	g.Expect(err).Should(BeNil())
+	defer os.RemoveAll(dir)
+	g.Expect(ioutil.WriteFile(path.Join(dir, "in.txt"), []byte("from stdin\n"), 0644)).Should(BeNil())
+
+	godbgFlags, programArgs := splitProgramArgs([]string{"-wd", "/tmp", "main.go", "--", "-v", "a"})
+	g.Expect(godbgFlags).Should(Equal([]string{"-wd", "/tmp", "main.go"}))
+	g.Expect(programArgs).Should(Equal([]string{"-v", "a"}))
+	args, err := splitCommandArgs(`x "y z"  'a "b"' c\ d ""`)
+	g.Expect(err).Should(BeNil())
+	g.Expect(args).Should(Equal([]string{"x", "y z", `a "b"`, "c d", ""}))
+	_, err = splitCommandArgs(`x "y`)
+	g.Expect(err).Should(Equal(UnterminatedQuoteErr))
+
+	execfile, _, err = build("./test_file/t14.go", buildOptions{})
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	target.execFile = execfile
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+
+	target.programOptions = programOptions{
+		args:   []string{"-v", "a"},
+		env:    []string{"GODBG_ENV=on"},
+		wd:     dir,
+		stdin:  path.Join(dir, "in.txt"),
+		stdout: path.Join(dir, "out.txt"),
+		stderr: path.Join(dir, "err.txt"),
+	}
+	target.cmd, err = runexec(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(os.Setenv("GODBG_TEST", "true")).Should(BeNil())
+
+	executor("b ./test_file/t14.go:15")
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("==>     15: \tfmt.Println(args, env, wd, strings.TrimSpace(line))"))
+	outw.Reset()
+	executor("p args")
+	g.Expect(outw.String()).Should(ContainSubstring("-v a"))
+	outw.Reset()
+	executor("c")
+	out, err = ioutil.ReadFile(path.Join(dir, "out.txt"))
+	g.Expect(err).Should(BeNil())
+	g.Expect(string(out)).Should(Equal(fmt.Sprintf("-v a on %s from stdin\n", dir)))
+	out, err = ioutil.ReadFile(path.Join(dir, "err.txt"))
+	g.Expect(err).Should(BeNil())
+	g.Expect(string(out)).Should(Equal("to stderr\n"))
+	g.Expect(errw.String()).Should(ContainSubstring("has exited with status 0"))
+	errw.Reset()
+
+	// the new process has the new arguments, and the breakpoint is kept
+	outw.Reset()
+	executor(`r x "y z"`)
+	g.Expect(target.args).Should(Equal([]string{"x", "y z"}))
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("==>     15:"))
+	outw.Reset()
+	executor("p args")
+	g.Expect(outw.String()).Should(ContainSubstring("x y z"))
+	g.Expect(errw.String()).Should(Equal(""))
+
+	executor("q")
+	clear_variable()
+}
+
+// dumpCore runs execfile which crashes, its core is dumped into dir.
+func dumpCore(execfile string, dir string) (string, error) {
+	var (
+		limit syscall.Rlimit
+		err   error
+	)
+	if err = syscall.Getrlimit(syscall.RLIMIT_CORE, &limit); err != nil {
+		return "", err
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_CORE, &limit)
+	if err = syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{Cur: limit.Max, Max: limit.Max}); err != nil {
+		return "", err
+	}
+	cmd := exec.Command(execfile)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOTRACEBACK=crash")
+	cmd.Run()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), "core") {
+			return path.Join(dir, info.Name()), nil
+		}
+	}
+	return "", fmt.Errorf("no core is dumped, please check /proc/sys/kernel/core_pattern")
+}
+
+func TestCore(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		corefile string
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	outw, errw := make_out_err()
+
+	// the segment is dumped at [0x1000, 0x1010), and zero initialized at [0x1010, 0x1020)
+	core := &Core{segments: []*coreSegment{{vaddr: 0x1000, filesz: 0x10, memsz: 0x20,
+		reader: bytes.NewReader(bytes.Repeat([]byte{1}, 0x10))}}}
+	buf := make([]byte, 0x10)
+	_, err = core.ReadMemory(0, 0x1008, buf)
+	g.Expect(err).Should(BeNil())
+	g.Expect(buf).Should(Equal(append(bytes.Repeat([]byte{1}, 8), make([]byte, 8)...)))
+	_, err = core.ReadMemory(0, 0x1018, buf)
+	g.Expect(err).ShouldNot(BeNil())
+
+	execfile, _, err = build("./test_file/t15.go", buildOptions{})
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	dir, err = ioutil.TempDir("", "godbg")
+	g.Expect(err).Should(BeNil())
+	defer os.RemoveAll(dir)
+	if corefile, err = dumpCore(execfile, dir); err != nil {
+		t.Skip(err)
+	}
+
+	g.Expect(coreTarget(execfile, corefile)).Should(BeNil())
+	g.Expect(target.core.signal).Should(Equal(syscall.SIGABRT))
+	g.Expect(os.Setenv("GODBG_TEST", "true")).Should(BeNil())
+
+	// the thread which crashed is unwound through the signal frame into the function which panics
+	executor("bt")
+	g.Expect(outw.String()).Should(ContainSubstring("runtime.sigreturn__sigaction [runtime]"))
+	g.Expect(outw.String()).Should(ContainSubstring("test_file/t15.go:11 main.withdraw"))
+	g.Expect(outw.String()).Should(ContainSubstring("test_file/t15.go:18 main.main"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	frames, err := target.bi.stacktrace(target.bp, currentThread(), maxStackDepth)
+	g.Expect(err).Should(BeNil())
+	n := 0
+	for n < len(frames) && (frames[n].fn == nil || frames[n].fn.name != "main.withdraw") {
+		n++
+	}
+	g.Expect(n).Should(BeNumerically("<", len(frames)))
+	executor(fmt.Sprintf("frame %d", n))
+	g.Expect(outw.String()).Should(ContainSubstring("==>     11: \tleft := *a.balance - n"))
+	outw.Reset()
+	executor("args")
+	g.Expect(outw.String()).Should(ContainSubstring("n = 10"))
+	outw.Reset()
+	executor("up")
+	outw.Reset()
+	executor("p s")
+	g.Expect(outw.String()).Should(ContainSubstring("hello core"))
+	outw.Reset()
+	executor("disass")
+	g.Expect(outw.String()).Should(ContainSubstring("t15.go"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+	// the goroutine which crashed runs on the current thread
+	executor("goroutines")
+	g.Expect(outw.String()).Should(MatchRegexp(`\*Goroutine 1 - running - pc=0x[0-9a-f]+ .* \(thread %d\)\n`, target.core.pid))
+	g.Expect(outw.String()).Should(ContainSubstring("waiting"))
+	outw.Reset()
+	// the code in the core is the same as the executable file
+	elffile, err := elf.Open(execfile)
+	g.Expect(err).Should(BeNil())
+	text := elffile.Section(".text")
+	code := make([]byte, 20)
+	_, err = text.ReadAt(code, 0)
+	g.Expect(err).Should(BeNil())
+	elffile.Close()
+	executor(fmt.Sprintf("x 0x%x 20", text.Addr))
+	g.Expect(outw.String()).Should(Equal(fmt.Sprintf("0x%x: % x\n0x%x: % x\n", text.Addr, code[:16], text.Addr+16, code[16:])))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	// the core can't be executed
+	executor("c")
+	g.Expect(errw.String()).Should(Equal(CoreReadOnlyErr.Error() + "\n"))
+	errw.Reset()
+	executor("b ./test_file/t15.go:11")
+	g.Expect(errw.String()).Should(Equal(CoreReadOnlyErr.Error() + "\n"))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestDumpCore(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	outw, errw := make_out_err()
+
+	dir, err = ioutil.TempDir("", "godbg")
+	g.Expect(err).Should(BeNil())
+	defer os.RemoveAll(dir)
+	corefile := path.Join(dir, "core")
+
+	execfile, err = build_run_debug("./test_file/t4.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+
+	executor("b ./test_file/t4.go:6")
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring(`==>      6: 	return fmt.Sprintf("m = %d", m)`))
+	outw.Reset()
+	executor("goroutines")
+	g.Expect(outw.String()).Should(MatchRegexp(`\*Goroutine 1 - running - pc=0x[0-9a-f]+ .*test_file/t4.go:6 main.pppp2 \(thread \d+\)\n`))
+	outw.Reset()
+	executor("dump " + corefile)
+	g.Expect(outw.String()).Should(ContainSubstring("dump the core of process"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+	executor("q")
+	clear_variable()
+	outw, errw = make_out_err()
+
+	// every segment is dumped completely, or not at all if it can't be read
+	elffile, err := elf.Open(corefile)
+	g.Expect(err).Should(BeNil())
+	end := uint64(0)
+	for _, prog := range elffile.Progs {
+		if prog.Type == elf.PT_LOAD {
+			g.Expect(prog.Filesz == 0 || prog.Filesz == prog.Memsz).Should(BeTrue())
+		}
+		if prog.Off+prog.Filesz > end {
+			end = prog.Off + prog.Filesz
+		}
+	}
+	elffile.Close()
+	info, err := os.Stat(corefile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(uint64(info.Size())).Should(Equal(end))
+
+	// the core has the state at the breakpoint, without the breakpoint
+	g.Expect(coreTarget(execfile, corefile)).Should(BeNil())
+	g.Expect(target.core.signal).Should(Equal(syscall.SIGTRAP))
+	executor("bt")
+	g.Expect(outw.String()).Should(ContainSubstring("test_file/t4.go:6 main.pppp2"))
+	g.Expect(outw.String()).Should(ContainSubstring("test_file/t4.go:11 main.pppp1"))
+	g.Expect(outw.String()).Should(ContainSubstring("test_file/t4.go:16 main.main"))
+	outw.Reset()
+	executor("args")
+	g.Expect(outw.String()).Should(ContainSubstring("m = 300"))
+	outw.Reset()
+	executor("disass")
+	g.Expect(outw.String()).ShouldNot(ContainSubstring("INT 0x3"))
+	g.Expect(errw.String()).Should(Equal(""))
+
+	executor("q")
+	clear_variable()
+}
+
+func TestDumpCoreFPRegisters(t *testing.T) {
+	var (
+		dir      string
+		execfile string
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	outw, errw := make_out_err()
+
+	dir, err = ioutil.TempDir("", "godbg")
+	g.Expect(err).Should(BeNil())
+	defer os.RemoveAll(dir)
+	corefile := path.Join(dir, "core")
+
+	wd, err := os.Getwd()
+	g.Expect(err).Should(BeNil())
+	execfile, _, err = build(path.Join(wd, "./test_file/t17.go"), buildOptions{optimized: true})
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	target.bi, err = analyze(execfile)
+	g.Expect(err).Should(BeNil())
+	target.cmd, err = runexec(execfile)
+	g.Expect(err).Should(BeNil())
+	g.Expect(os.Setenv("GODBG_TEST", "true")).Should(BeNil())
+
+	// the float argument is passed by xmm0 with the register abi
+	executor("b ./test_file/t17.go:7")
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("==>      7: \treturn f * 2"))
+	outw.Reset()
+	executor("args")
+	g.Expect(outw.String()).Should(ContainSubstring("f = 1.5"))
+	outw.Reset()
+	executor("dump " + corefile)
+	g.Expect(outw.String()).Should(ContainSubstring("dump the core of process"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+	executor("q")
+	clear_variable()
+	outw, errw = make_out_err()
+
+	// the fp registers are read from the NT_FPREGSET notes of the core
+	g.Expect(coreTarget(execfile, corefile)).Should(BeNil())
+	executor("args")
+	g.Expect(outw.String()).Should(ContainSubstring("f = 1.5"))
+	g.Expect(errw.String()).Should(Equal(""))
+
+	executor("q")
+	clear_variable()
+}
+
+// fakeProcess is the in-memory process, every instruction is one byte, the code runs to the next int3 or exits.
+type fakeProcess struct {
+	mem    map[uint64]byte
+	regs   syscall.PtraceRegs
+	fpregs []byte
+	end    uint64 // the process exits when pc reaches it
+	steps  int
+	resume int
+}
+
+func (p *fakeProcess) Pid() int       { return 1 }
+func (p *fakeProcess) Threads() []int { return []int{1} }
+
+func (p *fakeProcess) ReadMemory(tid int, addr uint64, buf []byte) (int, error) {
+	for i := range buf {
+		buf[i] = p.mem[addr+uint64(i)]
+	}
+	return len(buf), nil
+}
+
+func (p *fakeProcess) WriteMemory(tid int, addr uint64, data []byte) (int, error) {
+	for i, b := range data {
+		p.mem[addr+uint64(i)] = b
+	}
+	return len(data), nil
+}
+
+func (p *fakeProcess) Registers(tid int) (syscall.PtraceRegs, error) { return p.regs, nil }
+
+func (p *fakeProcess) SetRegisters(tid int, regs *syscall.PtraceRegs) error {
+	p.regs = *regs
+	return nil
+}
+
+func (p *fakeProcess) FPRegisters(tid int) ([]byte, error) {
+	if p.fpregs == nil {
+		return make([]byte, fpregsSize), nil
+	}
+	return p.fpregs, nil
+}
+
+func (p *fakeProcess) SetFPRegisters(tid int, fpregs []byte) error {
+	p.fpregs = fpregs
+	return nil
+}
+
+func (p *fakeProcess) Continue(tid int) error {
+	p.resume++
+	for pc := p.regs.PC(); pc < p.end; pc++ {
+		if p.mem[pc] == 0xCC {
+			// the trap of int3 is reported after it
+			p.regs.SetPC(pc + 1)
+			return nil
+		}
+	}
+	p.regs.SetPC(p.end)
+	return nil
+}
+
+func (p *fakeProcess) SingleStep(tid int) error {
+	p.steps++
+	p.regs.SetPC(p.regs.PC() + 1)
+	return nil
+}
+
+func (p *fakeProcess) Wait(tid int) (syscall.WaitStatus, error) {
+	if p.regs.PC() >= p.end {
+		return 0, nil
+	}
+	// stopped by SIGTRAP
+	return syscall.WaitStatus(0x7f | uint32(syscall.SIGTRAP)<<8), nil
+}
+
+func TestFakeProcess(t *testing.T) {
+	var (
+		reason StopReason
+		err    error
+	)
+	g := NewGomegaWithT(t)
+	clear_variable()
+	defer clear_variable()
+
+	fake := &fakeProcess{mem: map[uint64]byte{0x1000: 0x90, 0x1001: 0x90, 0x1002: 0x90, 0x1003: 0x90}, end: 0x1004}
+	fake.regs.SetPC(0x1000)
+	target.process = fake
+	target.cmd = &exec.Cmd{}
+	target.tid = 1
+	bp := target.bp
+
+	// the breakpoint is written into the memory of the backend
+	info, err := bp.SetInternalBreakPoint(1, 0x1002)
+	g.Expect(err).Should(BeNil())
+	g.Expect(info.original).Should(Equal([]byte{0x90}))
+	g.Expect(fake.mem[0x1002]).Should(Equal(byte(0xCC)))
+	buf := make([]byte, 4)
+	_, err = readMemory(1, 0x1000, buf)
+	g.Expect(err).Should(BeNil())
+	g.Expect(buf).Should(Equal([]byte{0x90, 0x90, 0xCC, 0x90}))
+
+	// continue to the breakpoint, then over it until the process exits
+	reason, err = bp.continueProcess(1)
+	g.Expect(err).Should(BeNil())
+	g.Expect(reason).Should(Equal(StopBreakPoint))
+	g.Expect(getPtracePc()).Should(Equal(uint64(0x1003)))
+	g.Expect(fake.resume).Should(Equal(1))
+
+	reason, err = bp.continueProcess(1)
+	g.Expect(err).Should(BeNil())
+	g.Expect(reason).Should(Equal(StopExited))
+	// the original instruction is executed by one step, and the breakpoint is enabled again
+	g.Expect(fake.steps).Should(Equal(1))
+	g.Expect(fake.mem[0x1002]).Should(Equal(byte(0xCC)))
+
+	g.Expect(bp.disableBreakPoint(1, info)).Should(BeNil())
+	g.Expect(fake.mem[0x1002]).Should(Equal(byte(0x90)))
+}
+
+func TestStacktraceUnwinders(t *testing.T) {
+	var (
+		frames []*Stackframe
+		err    error
+	)
+	g := NewGomegaWithT(t)
+	clear_variable()
+	defer clear_variable()
+	outw, errw := make_out_err()
+
+	fake := &fakeProcess{mem: map[uint64]byte{}}
+	writeUint64 := func(addr, v uint64) {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, v)
+		_, err := fake.WriteMemory(1, addr, buf)
+		g.Expect(err).Should(BeNil())
+	}
+	target.process = fake
+	target.cmd = &exec.Cmd{}
+	target.tid = 1
+
+	leaf := &Function{name: "main.leaf", lowpc: 0x1000, highpc: 0x1100, ranges: [][2]uint64{{0x1000, 0x1100}}}
+	mid := &Function{name: "main.mid", lowpc: 0x2000, highpc: 0x2100, ranges: [][2]uint64{{0x2000, 0x2100}}}
+	goexit := &Function{name: "runtime.goexit", lowpc: 0x3000, highpc: 0x3100, ranges: [][2]uint64{{0x3000, 0x3100}}}
+	cu := &CompileUnit{functions: []*Function{leaf, mid, goexit}}
+	line := func(file string, lineno int, addr uint64) *dwarf.LineEntry {
+		return &dwarf.LineEntry{Address: addr, File: &dwarf.LineFile{Name: file}, Line: lineno, IsStmt: true}
+	}
+	target.bi = &BI{
+		CompileUnits: []*CompileUnit{cu},
+		Functions:    cu.functions,
+		Sources: map[string]map[int][]*dwarf.LineEntry{
+			"leaf.go": {3: {line("leaf.go", 3, 0x1000)}, 4: {line("leaf.go", 4, 0x1008)}},
+			"mid.go":  {7: {line("mid.go", 7, 0x2000)}, 8: {line("mid.go", 8, 0x2018)}},
+			"asm.s":   {1: {line("asm.s", 1, 0x3000)}},
+		},
+		// only main.leaf is covered by the fde, main.mid keeps the frame pointer
+		FramesInformation: []*VirtualUnwindFrameInformation{{FDE: &FrameDescriptionEntry{
+			CIE: &CommonInformationEntry{
+				code_alignment_factor: 1,
+				data_alignment_factor: -8,
+				initial_instructions: []byte{
+					DW_CFA_def_cfa, 7, 8, // cfa = rsp + 8
+					DW_CFA_offset | 16, 1, // ra at cfa - 8
+				},
+			},
+			instructions: []byte{DW_CFA_advance_loc | 4, DW_CFA_def_cfa_offset, 24},
+			begin:        0x1000,
+			size:         0x100,
+		}}},
+	}
+	target.bi.buildFDEIndex()
+
+	// main.leaf stops after its prologue, rsp+24 is the cfa, and main.mid is called at 0x2020
+	fake.regs.SetPC(0x1010)
+	fake.regs.Rsp = 0x8000
+	fake.regs.Rbp = 0x9000
+	writeUint64(0x8010, 0x2020)
+	// main.mid saves rbp of its caller at [rbp], and runtime.goexit calls it at 0x3010
+	writeUint64(0x9000, 0)
+	writeUint64(0x9008, 0x3010)
+
+	frames, err = target.bi.stacktrace(target.bp, 1, maxStackDepth)
+	g.Expect(err).Should(BeNil())
+	g.Expect(len(frames)).Should(Equal(3))
+	g.Expect(frames[0].fn).Should(Equal(leaf))
+	g.Expect(frames[0].fp).Should(BeFalse())
+	g.Expect(frames[0].cfa).Should(Equal(uint64(0x8018)))
+	g.Expect(frames[0].ret).Should(Equal(uint64(0x2020)))
+	g.Expect(frames[1].fn).Should(Equal(mid))
+	g.Expect(frames[1].fp).Should(BeTrue())
+	g.Expect(frames[1].regs[dwarfRegRsp]).Should(Equal(uint64(0x8018)))
+	g.Expect(frames[1].cfa).Should(Equal(uint64(0x9010)))
+	g.Expect(frames[1].ret).Should(Equal(uint64(0x3010)))
+	g.Expect(frames[2].fn).Should(Equal(goexit))
+
+	executor("bt")
+	g.Expect(outw.String()).Should(MatchRegexp(`\*#0  pc=0x1010 .* cfa=0x8018 .* leaf.go:4 main.leaf\n`))
+	g.Expect(outw.String()).Should(MatchRegexp(` #1  pc=0x2020 .* cfa=0x9010 .* mid.go:8 main.mid\n`))
+	g.Expect(outw.String()).Should(MatchRegexp(` #2  pc=0x3010 .* asm.s:1 runtime.goexit \[runtime\]\n`))
+	g.Expect(errw.String()).Should(Equal(""))
+}
+
+func TestStepInstructionBreakPointKind(t *testing.T) {
+	var (
+		reason StopReason
+		err    error
+	)
+	g := NewGomegaWithT(t)
+	clear_variable()
+	defer clear_variable()
+
+	fake := &fakeProcess{mem: map[uint64]byte{0x1000: 0x90, 0x1001: 0x90, 0x1002: 0x90, 0x1003: 0x90}, end: 0x1004}
+	fake.regs.SetPC(0x1000)
+	target.process = fake
+	target.cmd = &exec.Cmd{}
+	target.tid = 1
+	bp := target.bp
+
+	// `si` doesn't stop on the internal breakpoints
+	_, err = bp.SetInternalBreakPoint(1, 0x1001)
+	g.Expect(err).Should(BeNil())
+	reason, err = bp.stepInstruction(1)
+	g.Expect(err).Should(BeNil())
+	g.Expect(reason).Should(Equal(StopDone))
+	g.Expect(getPtracePc()).Should(Equal(uint64(0x1001)))
+
+	bp.infos = append(bp.infos, &BInfo{original: []byte{0x90}, pc: 0x1002, kind: USERBPTYPE})
+	reason, err = bp.stepInstruction(1)
+	g.Expect(err).Should(BeNil())
+	g.Expect(reason).Should(Equal(StopBreakPoint))
+}
+
+func TestReadMemory(t *testing.T) {
+	var (
+		execfile string
+		pc       uint64
+		n        int
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	make_out_err()
+
+	execfile, err = build_run_debug("./test_file/t1.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	pid := target.cmd.Process.Pid
+	pc, err = getPtracePc()
+	g.Expect(err).Should(BeNil())
+
+	// the bulk reads cross the page boundary, and they are the same as PtracePeekData
+	addr := pc&^(pageSize-1) + pageSize - 100
+	want := make([]byte, 300)
+	_, err = syscall.PtracePeekData(pid, uintptr(addr), want)
+	g.Expect(err).Should(BeNil())
+	buf := make([]byte, 300)
+	n, err = processVMReadv(pid, addr, buf)
+	g.Expect(err).Should(BeNil())
+	g.Expect(n).Should(Equal(300))
+	g.Expect(buf).Should(Equal(want))
+	buf = make([]byte, 300)
+	n, err = readProcMem(pid, addr, buf)
+	g.Expect(err).Should(BeNil())
+	g.Expect(buf).Should(Equal(want))
+	buf = make([]byte, 300)
+	n, err = readMemory(pid, addr, buf)
+	g.Expect(err).Should(BeNil())
+	g.Expect(buf).Should(Equal(want))
+	g.Expect(target.pages).Should(HaveLen(2))
+
+	// the cached page is updated by the write of a breakpoint, and the cache is cleared by resuming
+	executor("b ./test_file/t1.go:7")
+	info := target.bp.infos[0]
+	b := make([]byte, 1)
+	_, err = readMemory(pid, info.pc, b)
+	g.Expect(err).Should(BeNil())
+	g.Expect(b).Should(Equal([]byte{0xCC}))
+	executor("c")
+	g.Expect(target.pages).Should(HaveLen(0))
+
+	// the unmapped memory can't be read
+	_, err = readMemory(pid, 0, b)
+	g.Expect(err).ShouldNot(BeNil())
+
+	executor("q")
+	clear_variable()
+}
+
+func TestSignal(t *testing.T) {
+	var (
+		execfile string
+		sig      syscall.Signal
+		err      error
+	)
+	g := NewGomegaWithT(t)
+	outw, errw := make_out_err()
+
+	sig, err = parseSignal("usr1")
+	g.Expect(err).Should(BeNil())
+	g.Expect(sig).Should(Equal(syscall.SIGUSR1))
+	sig, err = parseSignal("10")
+	g.Expect(err).Should(BeNil())
+	g.Expect(sig).Should(Equal(syscall.SIGUSR1))
+	_, err = parseSignal("SIGXXX")
+	g.Expect(err).ShouldNot(BeNil())
+	sig, err = parseSignal("SIG34")
+	g.Expect(err).Should(BeNil())
+	g.Expect(sig).Should(Equal(syscall.Signal(34)))
+
+	// the signal stops the program, and it is delivered when the program continues
+	execfile, err = build_run_debug("./test_file/t16.go")
+	g.Expect(err).Should(BeNil())
+	defer os.Remove(execfile)
+	target.execFile = execfile
+	executor("b ./test_file/t16.go:21")
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("received signal SIGUSR1"))
+	g.Expect(target.cmd.Process).ShouldNot(BeNil())
+	outw.Reset()
+	executor("c")
+	g.Expect(outw.String()).Should(ContainSubstring("==>     21: \tfmt.Println(result)"))
+	outw.Reset()
+	executor("p result")
+	g.Expect(outw.String()).Should(ContainSubstring("user defined signal 1"))
+	g.Expect(errw.String()).Should(Equal(""))
+	outw.Reset()
+
+	// the signal which isn't passed is dropped, and the program doesn't stop by it
+	executor("handle SIGUSR1 nostop noprint nopass")
+	g.Expect(outw.String()).Should(ContainSubstring("SIGUSR1    No    No    No"))
+	outw.Reset()
+	executor("r")
+	executor("c")
+	g.Expect(outw.String()).ShouldNot(ContainSubstring("received signal"))
+	g.Expect(outw.String()).Should(ContainSubstring("==>     21: \tfmt.Println(result)"))
+	outw.Reset()
+	executor("p result")
+	g.Expect(outw.String()).Should(ContainSubstring("timeout"))
+
+	// the realtime signal without name is listed after its policy is changed
+	executor("handle SIG34 nostop")
+	g.Expect(outw.String()).Should(ContainSubstring("SIG34      No    Yes   Yes"))
+	outw.Reset()
+	executor("handle")
+	g.Expect(outw.String()).Should(ContainSubstring("SIG34      No    Yes   Yes"))
+	g.Expect(outw.String()).Should(ContainSubstring("SIGUSR1    No    No    No"))
+	outw.Reset()
+
+	executor("handle SIGTRAP nostop")
+	g.Expect(errw.String()).Should(ContainSubstring("SIGTRAP is used by the debugger"))
+	errw.Reset()
+	executor("handle SIGUSR1 sometimes")
+	g.Expect(errw.String()).Should(ContainSubstring("unknown keyword `sometimes`"))
+
+	executor("q")
+	clear_variable()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const pageSize = 4096
+
+// the number of process_vm_readv on amd64, which isn't defined by syscall
+const sysProcessVMReadv = 310
+
+// pageCache keeps the pages of the stopped process which have been read, the memory doesn't change until
+// the process is resumed, so the cache is cleared when any thread is resumed and the pages are written.
+type pageCache map[uint64][]byte
+
+func (c *pageCache) clear() {
+	*c = nil
+}
+
+// invalidate removes the pages which overlap [addr, addr+size).
+func (c pageCache) invalidate(addr uint64, size int) {
+	for page := addr &^ (pageSize - 1); page < addr+uint64(size); page += pageSize {
+		delete(c, page)
+	}
+}
+
+// read reads the memory of the process pid at addr, the pages which aren't cached are read in bulk.
+// The bytes before the first page which can't be read are returned with the error, like PtracePeekData.
+func (c *pageCache) read(pid int, addr uint64, buf []byte) (int, error) {
+	var err error
+	if *c == nil {
+		*c = make(pageCache)
+	}
+	n := 0
+	for n < len(buf) {
+		cur := addr + uint64(n)
+		pageAddr := cur &^ (pageSize - 1)
+		page, ok := (*c)[pageAddr]
+		if !ok {
+			page = make([]byte, pageSize)
+			if err = readPage(pid, pageAddr, page); err != nil {
+				return n, err
+			}
+			(*c)[pageAddr] = page
+		}
+		n += copy(buf[n:], page[cur-pageAddr:])
+	}
+	return n, nil
+}
+
+// readPage reads the whole page at addr by process_vm_readv, /proc/<pid>/mem is used if the syscall is denied,
+// and PtracePeekData is the last resort.
+func readPage(pid int, addr uint64, page []byte) error {
+	n, err := processVMReadv(pid, addr, page)
+	if err == nil && n == len(page) {
+		return nil
+	}
+	logger.Debug("readPage:process_vm_readv", zap.Error(err), zap.Uint64("addr", addr))
+	if n, err = readProcMem(pid, addr, page); err == nil && n == len(page) {
+		return nil
+	}
+	logger.Debug("readPage:/proc/pid/mem", zap.Error(err), zap.Uint64("addr", addr))
+	if n, err = syscall.PtracePeekData(pid, uintptr(addr), page); err != nil {
+		return err
+	}
+	if n != len(page) {
+		return fmt.Errorf("read %d bytes of the page 0x%x", n, addr)
+	}
+	return nil
+}
+
+// iovec is struct iovec, the address in the other process isn't a pointer of godbg.
+type iovec struct {
+	base uint64
+	len  uint64
+}
+
+// processVMReadv reads the memory of the process pid by one syscall, without stopping the process.
+func processVMReadv(pid int, addr uint64, buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	local := iovec{base: uint64(uintptr(unsafe.Pointer(&buf[0]))), len: uint64(len(buf))}
+	remote := iovec{base: addr, len: uint64(len(buf))}
+	n, _, errno := syscall.Syscall6(sysProcessVMReadv, uintptr(pid),
+		uintptr(unsafe.Pointer(&local)), 1, uintptr(unsafe.Pointer(&remote)), 1, 0)
+	runtime.KeepAlive(buf)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+// readProcMem reads the memory of the process pid from /proc/<pid>/mem, which is readable by the tracer.
+func readProcMem(pid int, addr uint64, buf []byte) (int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.ReadAt(buf, int64(addr))
+}
+
+// examineMemory prints count bytes of the memory at addr, 16 bytes a line,
+// the breakpoints are shown as the original bytes.
+func examineMemory(bp *BP, pid int, addr uint64, count int) error {
+	buf := make([]byte, count)
+	n, err := readMemory(pid, addr, buf)
+	if n == 0 {
+		return err
+	}
+	buf = buf[:n]
+	bp.restoreOriginal(addr, buf)
+	for i := 0; i < len(buf); i += 16 {
+		line := buf[i:]
+		if len(line) > 16 {
+			line = line[:16]
+		}
+		fmt.Fprintf(stdout, "0x%x:", addr+uint64(i))
+		for _, b := range line {
+			fmt.Fprintf(stdout, " %02x", b)
+		}
+		fmt.Fprintf(stdout, "\n")
+	}
+	// the memory after the readable part is unmapped
+	return err
+}
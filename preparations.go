@@ -1,34 +1,213 @@
 package main
 
 import (
+	"debug/elf"
 	"errors"
+	"flag"
+	"fmt"
 	"go.uber.org/zap"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 )
 
-func checkArgs() error {
-	logger.Debug("[checkArgs]", zap.Strings("args", os.Args))
-	if len(os.Args) != 3 {
-		return errors.New("len(args) != 3")
+// buildOptions are the options of `go build`, the program is built without optimization by default.
+type buildOptions struct {
+	optimized bool // keep the optimizations and inlining, to debug the same binary as the shipped one
+	tags      string
+	mod       string
+	race      bool
+	gcflags   string // the extra flags of the compiler, which are merged with `-N -l`
+	ldflags   string
+	test      bool // build the test binary of the package by `go test -c`
+}
+
+// programOptions are how the debugged program runs, they are kept by `restart`.
+type programOptions struct {
+	args   []string // the arguments of the debugged program
+	env    []string // the extra environment variables, KEY=VAL, which are added to the environment of godbg
+	wd     string   // the working directory of the debugged program, "" is the current directory
+	stdin  string   // the file which is read as stdin, "" is the stdin of godbg
+	stdout string   // the file which stdout is written to, "" is the stdout of godbg
+	stderr string   // the file which stderr is written to, "" is the stderr of godbg
+	tty    string   // the terminal which is stdin, stdout and stderr, so the output isn't mixed with godbg
+}
+
+// stringsFlag is the flag which can be repeated, like `-env A=1 -env B=2`.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// programFlags adds the flags of the debugged program into flags.
+func (program *programOptions) programFlags(flags *flag.FlagSet) {
+	flags.Var((*stringsFlag)(&program.env), "env", "the environment variable KEY=VAL, may be repeated")
+	flags.StringVar(&program.wd, "wd", "", "the working directory")
+	flags.StringVar(&program.stdin, "stdin", "", "the file which is read as stdin")
+	flags.StringVar(&program.stdout, "stdout", "", "the file which stdout is written to")
+	flags.StringVar(&program.stderr, "stderr", "", "the file which stderr is written to")
+	flags.StringVar(&program.tty, "tty", "", "the terminal which is stdin, stdout and stderr")
+}
+
+// splitProgramArgs splits the arguments at `--`, the arguments after it are passed to the debugged program.
+func splitProgramArgs(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
 	}
-	debug := os.Args[1]
+	return args, nil
+}
 
-	if debug != "debug" {
-		return errors.New("only support `debug`")
+// splitCommandArgs splits the arguments of a command like the shell, the arguments are separated by spaces,
+// and the spaces in the quotes or after `\` are a part of the argument.
+func splitCommandArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		arg     []rune
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, c := range s {
+		switch {
+		case escaped:
+			arg = append(arg, c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg = append(arg, c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, string(arg))
+				arg, inArg = nil, false
+			}
+		default:
+			arg, inArg = append(arg, c), true
+		}
 	}
-	if path.Ext(os.Args[2]) != ".go" {
-		return errors.New("please input .go file")
+	if quote != 0 || escaped {
+		return nil, UnterminatedQuoteErr
 	}
-	return nil
+	if inArg {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+// godbgArgs are the arguments of godbg, `godbg debug [flags] <file.go|dir|import path> [-- args]`,
+// `godbg test [flags] [-run regexp] [-v] [dir|import path] [-- args]`, `godbg exec [flags] <binary> [-- args]`
+// `godbg attach <pid>` or `godbg core <binary> <corefile>`.
+type godbgArgs struct {
+	command  string
+	filename string // the .go file, directory or import path of `debug` and `test`, or the executable file of `exec`
+	pid      int    // the process of `attach`
+	opts     buildOptions
+	program  programOptions
+	corefile string // the core file of `core`, which is dumped by filename
+}
+
+// checkArgs parses the arguments of godbg.
+func checkArgs() (*godbgArgs, error) {
+	var err error
+	logger.Debug("[checkArgs]", zap.Strings("args", os.Args))
+	if len(os.Args) < 2 || (len(os.Args) < 3 && os.Args[1] != "test") {
+		return nil, errors.New("len(args) < 3")
+	}
+	args := &godbgArgs{command: os.Args[1]}
+	godbgFlags, programArgs := splitProgramArgs(os.Args[2:])
+
+	switch args.command {
+	case "debug", "test":
+		var (
+			run     string
+			verbose bool
+		)
+		flags := flag.NewFlagSet(args.command, flag.ContinueOnError)
+		flags.SetOutput(ioutil.Discard)
+		args.program.programFlags(flags)
+		flags.BoolVar(&args.opts.optimized, "O", false, "build with optimizations")
+		flags.StringVar(&args.opts.tags, "tags", "", "the build tags")
+		flags.StringVar(&args.opts.mod, "mod", "", "the module download mode")
+		flags.BoolVar(&args.opts.race, "race", false, "enable the race detector")
+		flags.StringVar(&args.opts.gcflags, "gcflags", "", "the extra flags of the compiler")
+		flags.StringVar(&args.opts.ldflags, "ldflags", "", "the flags of the linker")
+		if args.command == "test" {
+			flags.StringVar(&run, "run", "", "run only the tests matching the regexp")
+			flags.BoolVar(&verbose, "v", false, "verbose output of the tests")
+		}
+		if err = flags.Parse(godbgFlags); err != nil {
+			return nil, err
+		}
+		if args.command == "test" {
+			// `godbg test` debugs the package in current directory by default
+			args.filename = "."
+			args.opts.test = true
+			if run != "" {
+				args.program.args = append(args.program.args, "-test.run", run)
+			}
+			if verbose {
+				args.program.args = append(args.program.args, "-test.v")
+			}
+		}
+		args.program.args = append(args.program.args, programArgs...)
+		if flags.NArg() > 1 || (flags.NArg() == 0 && args.command == "debug") {
+			return nil, errors.New("please input only one .go file, directory or import path")
+		}
+		if flags.NArg() == 1 {
+			args.filename = flags.Arg(0)
+		}
+	case "exec":
+		flags := flag.NewFlagSet(args.command, flag.ContinueOnError)
+		flags.SetOutput(ioutil.Discard)
+		args.program.programFlags(flags)
+		if err = flags.Parse(godbgFlags); err != nil {
+			return nil, err
+		}
+		if flags.NArg() != 1 {
+			return nil, errors.New("please input only one executable file")
+		}
+		args.filename = flags.Arg(0)
+		args.program.args = programArgs
+	case "attach":
+		if len(os.Args) != 3 {
+			return nil, errors.New("please input only one pid")
+		}
+		if args.pid, err = strconv.Atoi(os.Args[2]); err != nil || args.pid <= 0 {
+			return nil, errors.New("please input the pid of process")
+		}
+	case "core":
+		if len(os.Args) != 4 {
+			return nil, errors.New("please input the executable file and the core file")
+		}
+		args.filename = os.Args[2]
+		args.corefile = os.Args[3]
+	default:
+		return nil, errors.New("only support `debug`, `test`, `exec`, `attach` and `core`")
+	}
+	return args, nil
 }
 
-func absoluteFilename() (string, error) {
-	filename := os.Args[2]
+func absoluteFilename(filename string) (string, error) {
 	if path.IsAbs(filename) {
 		return filename, nil
 	}
@@ -46,21 +225,214 @@ func absoluteFilename() (string, error) {
 	return filename, nil
 }
 
-func build(filename string) (string, error) {
-	base := filepath.Base(filename)
-	execfile := path.Join(os.TempDir(), "__"+base+"__")
+// checkExecutable checks the executable file which is built by others, which may have no DWARF.
+func checkExecutable(execfile string) error {
+	elffile, err := elf.Open(execfile)
+	if err != nil {
+		return err
+	}
+	defer elffile.Close()
+	if elffile.Section(".debug_info") == nil && elffile.Section(".zdebug_info") == nil {
+		return fmt.Errorf("%s has no DWARF, it may be built with `-ldflags=-w` or stripped", execfile)
+	}
+	return nil
+}
+
+// goBuildArgs returns the arguments of `go build`, which builds pkg into execfile.
+func (opts buildOptions) goBuildArgs(execfile string, pkg string) []string {
+	args := []string{"build"}
+	if opts.test {
+		args = []string{"test", "-c"}
+	}
+	if opts.tags != "" {
+		args = append(args, "-tags", opts.tags)
+	}
+	if opts.mod != "" {
+		args = append(args, "-mod", opts.mod)
+	}
+	if opts.race {
+		args = append(args, "-race")
+	}
+	if opts.optimized {
+		// the variables may be optimized out, or have location lists
+		if opts.gcflags != "" {
+			args = append(args, "-gcflags", opts.gcflags)
+		}
+	} else {
+		args = append(args, "-gcflags", "all=-N -l")
+		if opts.gcflags != "" {
+			// the last -gcflags of the same packages wins, so `-N -l` is kept in the extra flags,
+			// the flags without pattern are for the packages in the command line
+			pattern, flags := "", opts.gcflags
+			if i := strings.Index(flags, "="); i > 0 && !strings.HasPrefix(flags, "-") {
+				pattern, flags = flags[:i+1], flags[i+1:]
+			}
+			args = append(args, "-gcflags", pattern+"-N -l "+flags)
+		}
+	}
+	if opts.ldflags != "" {
+		args = append(args, "-ldflags", opts.ldflags)
+	}
+	return append(args, "-o", execfile, pkg)
+}
+
+// moduleRoot returns the nearest directory from dir up which contains go.mod, or "" if not found.
+func moduleRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// resolvePackage returns the directory where `go build` runs, the package which is built, and the name of it.
+// The target may be a .go file, a directory or an import path.
+func resolvePackage(target string) (string, string, string, error) {
+	var (
+		cwd string
+		abs string
+		err error
+	)
+	if cwd, err = os.Getwd(); err != nil {
+		return "", "", "", err
+	}
+	// an import path, which is found by the module of current directory
+	if !filepath.IsAbs(target) && !strings.HasPrefix(target, ".") && path.Ext(target) != ".go" {
+		if root := moduleRoot(cwd); root != "" {
+			return root, target, path.Base(target), nil
+		}
+		return cwd, target, path.Base(target), nil
+	}
+
+	if abs, err = filepath.Abs(target); err != nil {
+		return "", "", "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", "", "", err
+	}
+	dir := abs
+	if !info.IsDir() {
+		dir = filepath.Dir(abs)
+	}
+	root := moduleRoot(dir)
+	if root == "" {
+		root = dir
+	}
+	if !info.IsDir() {
+		return root, abs, filepath.Base(abs), nil
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", "", "", err
+	}
+	return root, "./" + filepath.ToSlash(rel), filepath.Base(abs), nil
+}
+
+// packageDir returns the directory of the package pkg, where its tests run.
+func packageDir(dir string, pkg string) (string, error) {
+	cmd := exec.Command("go", "list", "-f", "{{.Dir}}", pkg)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
 
-	args := []string{"build", "-gcflags", "all=-N -l", "-o", execfile, filename}
+// build builds the target in the root of its module, the target may be a .go file, a directory or an import path.
+// The test binary is built if opts.test, and the directory of its package is returned too,
+// where it runs like `go test`.
+func build(filename string, opts buildOptions) (string, string, error) {
+	var pkgDir string
+	dir, pkg, name, err := resolvePackage(filename)
+	if err != nil {
+		return "", "", err
+	}
+	if opts.test {
+		name += ".test"
+		if pkgDir, err = packageDir(dir, pkg); err != nil {
+			return "", "", err
+		}
+	}
+	execfile := path.Join(os.TempDir(), "__"+name+"__")
 
+	args := opts.goBuildArgs(execfile, pkg)
+	logger.Debug("build", zap.String("dir", dir), zap.Strings("args", args))
 	cmd := exec.Command("go", args...)
-	return execfile, cmd.Run()
+	cmd.Dir = dir
+	cmd.Stderr = stderr
+	return execfile, pkgDir, cmd.Run()
+}
+
+// openProgramFiles opens the files which stdin, stdout and stderr of the debugged program are redirected to.
+// The files are closed by the caller after the program starts.
+func openProgramFiles(cmd *exec.Cmd) ([]*os.File, error) {
+	var (
+		files []*os.File
+		f     *os.File
+		err   error
+	)
+	if target.tty != "" {
+		if f, err = os.OpenFile(target.tty, os.O_RDWR, 0); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = f, f, f
+	}
+	if target.stdin != "" {
+		if f, err = os.Open(target.stdin); err != nil {
+			return files, err
+		}
+		files = append(files, f)
+		cmd.Stdin = f
+	}
+	if target.stdout != "" {
+		if f, err = os.Create(target.stdout); err != nil {
+			return files, err
+		}
+		files = append(files, f)
+		cmd.Stdout = f
+	}
+	if target.stderr != "" {
+		// stdout and stderr share the file, so one doesn't overwrite the other
+		if target.stderr != target.stdout {
+			if f, err = os.Create(target.stderr); err != nil {
+				return files, err
+			}
+			files = append(files, f)
+		}
+		cmd.Stderr = f
+	}
+	return files, nil
 }
 
-// not supoort arguments of cmds
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// runexec starts execfile with the arguments, environment, working directory and redirections of target.
 func runexec(execfile string) (*exec.Cmd, error) {
-	cmd := exec.Command(execfile)
+	cmd := exec.Command(execfile, target.args...)
+	cmd.Dir = target.wd
+	if len(target.env) > 0 {
+		cmd.Env = append(os.Environ(), target.env...)
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	files, err := openProgramFiles(cmd)
+	// the child has its own descriptors after starting
+	defer closeFiles(files)
+	if err != nil {
+		return nil, err
+	}
 	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true, Setpgid: true, Foreground: false}
 
 	// !!! maybe the diffrences of routine and thread in golang
@@ -75,5 +447,15 @@ func runexec(execfile string) (*exec.Cmd, error) {
 		logger.Error("runexec:cmd.Wait()", zap.Error(err))
 		return nil, err
 	}
+	// the goroutines run on any thread, so every thread is traced
+	if err := syscall.PtraceSetOptions(cmd.Process.Pid, syscall.PTRACE_O_TRACECLONE); err != nil {
+		logger.Error("runexec:PtraceSetOptions", zap.Error(err))
+		return nil, err
+	}
+	target.threads = []int{cmd.Process.Pid}
+	target.tid = cmd.Process.Pid
+	target.starting = make(map[int]bool)
+	target.pages.clear()
+	target.signals.clearPending()
 	return cmd, nil
 }
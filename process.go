@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Process is the backend of the debugged process, the commands read and change the process only by it,
+// so the live process, the core file and the fake process of the tests are debugged in the same way.
+// The threads are stopped together, tid is the thread which is inspected or resumed.
+type Process interface {
+	Pid() int
+	Threads() []int
+	ReadMemory(tid int, addr uint64, buf []byte) (int, error)
+	WriteMemory(tid int, addr uint64, data []byte) (int, error)
+	Registers(tid int) (syscall.PtraceRegs, error)
+	SetRegisters(tid int, regs *syscall.PtraceRegs) error
+	// FPRegisters returns the x87, MMX and SSE registers in the layout of FXSAVE, which is user_fpregs_struct.
+	FPRegisters(tid int) ([]byte, error)
+	SetFPRegisters(tid int, fpregs []byte) error
+	// Continue resumes all the threads, the thread tid is resumed last.
+	Continue(tid int) error
+	// SingleStep executes one instruction of the thread tid, the other threads keep stopped.
+	SingleStep(tid int) error
+	// Wait waits for the thread tid, or any thread of the process if tid is -1,
+	// then the thread which stops becomes the current thread and all the other threads are stopped.
+	Wait(tid int) (syscall.WaitStatus, error)
+}
+
+// currentProcess returns the backend of the target, it is the process which is traced by ptrace by default.
+func currentProcess() Process {
+	if target.process != nil {
+		return target.process
+	}
+	return nativeProcess{}
+}
+
+// readMemory reads the memory of the process, it may be the core file of `godbg core`.
+func readMemory(pid int, addr uint64, buf []byte) (int, error) {
+	return currentProcess().ReadMemory(pid, addr, buf)
+}
+
+// nativeProcess is the process which is started or attached by godbg, all of its threads are traced by ptrace.
+type nativeProcess struct{}
+
+func (nativeProcess) Pid() int {
+	if target.cmd == nil || target.cmd.Process == nil {
+		return 0
+	}
+	return target.cmd.Process.Pid
+}
+
+func (p nativeProcess) Threads() []int {
+	if len(target.threads) == 0 {
+		return []int{p.Pid()}
+	}
+	return target.threads
+}
+
+func (nativeProcess) ReadMemory(tid int, addr uint64, buf []byte) (int, error) {
+	return target.pages.read(tid, addr, buf)
+}
+
+func (nativeProcess) WriteMemory(tid int, addr uint64, data []byte) (int, error) {
+	target.pages.invalidate(addr, len(data))
+	return syscall.PtracePokeData(tid, uintptr(addr), data)
+}
+
+func (nativeProcess) Registers(tid int) (syscall.PtraceRegs, error) {
+	var regs syscall.PtraceRegs
+	err := syscall.PtraceGetRegs(tid, &regs)
+	return regs, err
+}
+
+func (nativeProcess) SetRegisters(tid int, regs *syscall.PtraceRegs) error {
+	return syscall.PtraceSetRegs(tid, regs)
+}
+
+func (nativeProcess) FPRegisters(tid int) ([]byte, error) {
+	fpregs := make([]byte, fpregsSize)
+	if _, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_GETFPREGS, uintptr(tid), 0,
+		uintptr(unsafe.Pointer(&fpregs[0])), 0, 0); errno != 0 {
+		return nil, errno
+	}
+	return fpregs, nil
+}
+
+func (nativeProcess) SetFPRegisters(tid int, fpregs []byte) error {
+	if len(fpregs) != fpregsSize {
+		return fmt.Errorf("invalid size %d of the fp registers", len(fpregs))
+	}
+	if _, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_SETFPREGS, uintptr(tid), 0,
+		uintptr(unsafe.Pointer(&fpregs[0])), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (nativeProcess) Continue(tid int) error {
+	target.pages.clear()
+	if err := resumeOtherThreads(tid); err != nil {
+		return err
+	}
+	return syscall.PtraceCont(tid, target.signals.takePending(tid))
+}
+
+func (nativeProcess) SingleStep(tid int) error {
+	target.pages.clear()
+	return syscall.PtraceSingleStep(tid)
+}
+
+func (nativeProcess) Wait(tid int) (syscall.WaitStatus, error) {
+	var (
+		s   syscall.WaitStatus
+		err error
+	)
+	if tid == -1 {
+		err = waitThreads(target.bp, &s)
+		return s, err
+	}
+	for {
+		if _, err = syscall.Wait4(tid, &s, syscall.WALL, nil); err != nil || !s.Stopped() {
+			return s, err
+		}
+		sig := s.StopSignal()
+		// the policy is applied once to the signals of the program
+		stop := sig == syscall.SIGTRAP
+		if !stop {
+			stop = target.signals.received(tid, sig)
+		}
+		switch {
+		case sig == syscall.SIGTRAP && s.TrapCause() == syscall.PTRACE_EVENT_CLONE:
+			// the new thread keeps stopped by its SIGSTOP, which is waited when the process is continued
+			addClonedThread(tid)
+		case stop:
+			return s, nil
+		}
+		// the step isn't finished, the signal which is passed is delivered when the process is continued
+		if err = syscall.PtraceSingleStep(tid); err != nil {
+			return s, err
+		}
+	}
+}
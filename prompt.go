@@ -7,15 +7,19 @@ import (
 	"fmt"
 	"github.com/c-bata/go-prompt"
 	"go.uber.org/zap"
-	"golang.org/x/arch/x86/x86asm"
 	"os"
 	"path"
 	"strconv"
 	"strings"
-	"syscall"
-	"unsafe"
 )
 
+// resumeCommands are the commands which resume the process, or move it back by the record.
+var resumeCommands = map[string]bool{
+	"c": true, "continue": true, "s": true, "step": true, "si": true, "stepi": true,
+	"n": true, "next": true, "ni": true, "nexti": true,
+	"rsi": true, "reverse-stepi": true, "rn": true, "reverse-next": true, "rc": true, "reverse-continue": true,
+}
+
 // executor will exec for input.
 // please keep the sync of printCmdHelper in error.go
 func executor(input string) {
@@ -28,18 +32,31 @@ func executor(input string) {
 	cmd := target.cmd
 	bp := target.bp
 	bi := target.bi
-	pid := int(0)
-	if cmd != nil && cmd.Process != nil {
-		pid = cmd.Process.Pid
+	pid := currentThread()
+
+	// the core can't be changed or executed
+	if target.core != nil && !isCoreCommand(input) {
+		printErr(CoreReadOnlyErr)
+		return
+	}
+	// the process stops somewhere else after it is resumed, the innermost frame is selected again
+	if resumeCommands[strings.Split(input, " ")[0]] {
+		target.frame = 0
 	}
 
 	switch fs {
 	case 'q':
 		if input == "q" || input == "quit" {
-			if cmd.Process != nil {
-				if err := syscall.Kill(cmd.Process.Pid, syscall.SIGKILL); err != nil {
-					// printErr(err)
+			if target.core != nil {
+				// there is no process of the core
+				target.core.Close()
+			} else if cmd.Process != nil && target.attached {
+				// the attached process keeps running
+				if err := detach(bp); err != nil {
+					printErr(err)
 				}
+			} else if cmd.Process != nil {
+				killProcess(cmd.Process.Pid)
 			}
 			if os.Getenv("GODBG_TEST") != "" {
 				return
@@ -93,37 +110,41 @@ func executor(input string) {
 			}
 
 			if needClearIndex, err := strconv.Atoi(sps[1]); err == nil {
-				if needClearIndex > len(bp.infos) {
+				groups := bp.userBreakPoints()
+				if needClearIndex <= 0 || needClearIndex > len(groups) {
 					printErr(fmt.Errorf("can't find breakpoint index %d", needClearIndex))
+					return
 				}
-				count := 0
-				for i, v := range bp.infos {
-					if v.kind == USERBPTYPE {
-						count++
-						if count == needClearIndex {
-							_ = bp.disableBreakPoint(pid, v)
-							if v.pc == curPc-1 {
-								_ = setPcRegister(cmd, v.pc)
-							}
-							bp.infos = append(bp.infos[:i], bp.infos[(i+1):len(bp.infos)]...)
-							_, _ = fmt.Fprintf(stdout, "clear breakpoint %d successfully, resort breakpoint again\n", needClearIndex)
-							return
-						}
+				// every inlined copy of the breakpoint is cleared
+				cleared := make(map[*BInfo]bool)
+				for _, v := range groups[needClearIndex-1] {
+					_ = bp.disableBreakPoint(pid, v)
+					if v.pc == curPc-1 {
+						_ = setPcRegister(cmd, v.pc)
 					}
+					cleared[v] = true
 				}
-				printErr(fmt.Errorf("can't find breakpoint index %d", needClearIndex))
+				tmp := make([]*BInfo, 0, len(bp.infos))
+				for _, v := range bp.infos {
+					if !cleared[v] {
+						tmp = append(tmp, v)
+					}
+				}
+				bp.infos = tmp
+				_, _ = fmt.Fprintf(stdout, "clear breakpoint %d successfully, resort breakpoint again\n", needClearIndex)
 				return
 			}
 		}
 		if len(sps) == 1 && (sps[0] == "bl") {
-			count := 0
-			for _, v := range bp.infos {
-				if v.kind == USERBPTYPE {
-					count++
-					fmt.Fprintf(stdout, "%-2d. %s:%d, pc 0x%x\n", count, v.filename, v.lineno, v.pc)
+			groups := bp.userBreakPoints()
+			for i, group := range groups {
+				pcs := make([]string, 0, len(group))
+				for _, v := range group {
+					pcs = append(pcs, fmt.Sprintf("0x%x", v.pc))
 				}
+				fmt.Fprintf(stdout, "%-2d. %s:%d, pc %s\n", i+1, group[0].filename, group[0].lineno, strings.Join(pcs, ", "))
 			}
-			if count == 0 {
+			if len(groups) == 0 {
 				fmt.Fprintf(stdout, "there is no breakpoint\n")
 			}
 			return
@@ -139,109 +160,150 @@ func executor(input string) {
 			}
 			return
 		}
-		if len(sps) == 1 && sps[0] == "bt" {
+		if len(sps) >= 1 && (sps[0] == "bt" || sps[0] == "backtrace") {
 			var (
-				rbp      uint64
+				depth    = maxStackDepth
+				hasDepth bool
+				args     bool
+				full     bool
 				err      error
-				filename string
-				line     int
-				pc       uint64
-				f        *Function
-				ok       bool
 			)
-			if rbp, err = getPtraceBp(); err != nil {
-				printErr(err)
-				printErr(fmt.Errorf("!!1 %s", err.Error()))
-				return
+			for _, sp := range sps[1:] {
+				switch sp {
+				case "-args":
+					args = true
+				case "-full":
+					full = true
+				default:
+					// only one depth is given
+					if hasDepth {
+						printUnsupportCmd(input)
+						return
+					}
+					if depth, err = strconv.Atoi(sp); err != nil || depth <= 0 {
+						printUnsupportCmd(input)
+						return
+					}
+					hasDepth = true
+				}
 			}
-			if pc, err = getPtracePc(); err != nil {
+			if err = bi.printStacktrace(bp, pid, depth, args, full); err != nil {
 				printErr(err)
-				printErr(fmt.Errorf("!!2 %s", err.Error()))
 				return
 			}
-
-			if _, ok = bp.findBreakPoint(pc - 1); ok {
-				pc = pc - 1
+			return
+		}
+	case 'c':
+		sps := strings.Split(input, " ")
+		if len(sps) <= 2 && (sps[0] == "c" || sps[0] == "continue") {
+			count, err := parseCount(sps)
+			if err != nil {
+				printUnsupportCmd(input)
+				return
 			}
-			if filename, line, err = bi.pcTofileLine(pc); err != nil {
-				printErr(err)
-				printErr(fmt.Errorf("!!3 %s", err.Error()))
+			if cmd.Process == nil {
+				printNoProcessErr()
 				return
 			}
-			fmt.Fprintf(stdout, "%s:%d\n", filename, line)
-
-			ret := uint64(0)
-			for {
-				if uintptr(rbp) == 0 {
+			var (
+				reason StopReason
+				pc     uint64
+			)
+			for i := 0; i < count; i++ {
+				// the current thread changes if another thread traps
+				if reason, err = bp.continueProcess(currentThread()); reason != StopBreakPoint || err != nil {
 					break
 				}
-				original := make([]byte, 16)
-				_, err = syscall.PtracePeekData(cmd.Process.Pid, uintptr(rbp), original)
-				if err != nil {
-					printErr(err)
-					return
-				}
-				reader := bytes.NewBuffer(original)
-
-				if err = binary.Read(reader, binary.LittleEndian, &rbp); err != nil {
-					printErr(err)
-					return
-				}
-				if err = binary.Read(reader, binary.LittleEndian, &ret); err != nil {
-					printErr(err)
-					return
-				}
-				//fmt.Fprintf(stdout, "ret = %d\n", ret)
-				if filename, line, err = bi.pcTofileLine(ret - 1); err != nil {
-					printErr(err)
-					return
-				}
-				if f, err = bi.findFunctionIncludePc(ret - 1); err != nil {
-					printErr(err)
-					return
-				}
-				fmt.Fprintf(stdout, "%s:%d %s\n", filename, line, f.name)
+			}
+			if !printStopReason(reason, err) {
+				return
 			}
 
+			if pc, err = getPtracePc(); err != nil {
+				printErr(err)
+				return
+			}
+			fmt.Fprintf(stdout, "current process pc = 0x%x\n", pc)
+			if err = listFileLineByPtracePc(target.bi, 6); err != nil {
+				printErr(err)
+				return
+			}
 			return
 		}
-	case 'c':
+	case 's':
 		sps := strings.Split(input, " ")
-		if len(sps) == 1 && (sps[0] == "c" || sps[0] == "continue") {
+		if len(sps) == 1 && sps[0] == "skip" {
+			if len(target.skip.patterns) == 0 {
+				fmt.Fprintf(stdout, "there is no skip pattern\n")
+				return
+			}
+			for i, pattern := range target.skip.patterns {
+				fmt.Fprintf(stdout, "%-2d. %s\n", i+1, pattern)
+			}
+			return
+		}
+		if len(sps) == 3 && sps[0] == "skip" && (sps[1] == "add" || sps[1] == "del") {
+			var err error
+			if sps[1] == "add" {
+				err = target.skip.Add(sps[2])
+			} else {
+				err = target.skip.Delete(sps[2])
+			}
+			if err != nil {
+				printErr(err)
+				return
+			}
+			fmt.Fprintf(stdout, "skip %s `%s` successfully\n", sps[1], sps[2])
+			return
+		}
+		if len(sps) <= 2 && (sps[0] == "si" || sps[0] == "stepi") {
+			count, err := parseCount(sps)
+			if err != nil {
+				printUnsupportCmd(input)
+				return
+			}
 			if cmd.Process == nil {
 				printNoProcessErr()
 				return
 			}
-			if err := bp.singleStepInstructionWithBreakpointCheck(pid); err != nil {
-				printErr(err)
+			var reason StopReason
+			for i := 0; i < count; i++ {
+				if reason, err = bp.stepInstruction(pid); reason != StopDone || err != nil {
+					break
+				}
+			}
+			if !printStopReason(reason, err) {
 				return
 			}
-			if err := bp.Continue(pid); err != nil {
+			if err = listInstructionByPtracePc(bi, bp, pid); err != nil {
 				printErr(err)
 				return
 			}
-			var (
-				s  syscall.WaitStatus
-				pc uint64
-			)
-			wpid, err := syscall.Wait4(cmd.Process.Pid, &s, syscall.WALL, nil)
+			return
+		}
+		if len(sps) <= 2 && (sps[0] == "s" || sps[0] == "step") {
+			count, err := parseCount(sps)
 			if err != nil {
-				printErr(err)
+				printUnsupportCmd(input)
 				return
 			}
-
-			if s.Exited() {
-				printExit0(wpid)
-				cmd.Process = nil
+			if cmd.Process == nil {
+				printNoProcessErr()
 				return
 			}
-
-			if n := s.StopSignal(); n != syscall.SIGTRAP && n != syscall.SIGURG {
-				cmd.Process = nil
-				fmt.Errorf("unknown waitstatus %v, signal %d", s, s.Signal())
+			var (
+				reason StopReason
+				pc     uint64
+			)
+			for i := 0; i < count; i++ {
+				// the current thread changes if a call returns on another thread
+				if reason, err = bp.stepLine(bi, currentThread()); reason != StopDone || err != nil {
+					break
+				}
+			}
+			if !printStopReason(reason, err) {
 				return
 			}
-
 			if pc, err = getPtracePc(); err != nil {
 				printErr(err)
 				return
@@ -253,206 +315,79 @@ func executor(input string) {
 			}
 			return
 		}
-	case 's':
+	case 'n':
 		sps := strings.Split(input, " ")
-		if len(sps) == 1 && (sps[0] == "s" || sps[0] == "step") {
-			var (
-				err         error
-				filename    string
-				lineno      int
-				oldfilename string
-				oldlineno   int
-				pc          uint64
-				info        *BInfo
-				ok          bool
-			)
-			if oldfilename, oldlineno, err = bi.getCurFileLineByPtracePc(); err != nil {
-				printErr(err)
+		if len(sps) <= 2 && (sps[0] == "ni" || sps[0] == "nexti") {
+			count, err := parseCount(sps)
+			if err != nil {
+				printUnsupportCmd(input)
 				return
 			}
-			for {
-				if pc, err = getPtracePc(); err != nil {
-					printErr(err)
-					return
-				}
-				if filename, lineno, err = bi.pcTofileLine(pc); err != nil {
-					printErr(err)
-					return
-				}
-
-				if !(filename == oldfilename && lineno == oldlineno) {
-					fmt.Fprintf(stdout, "current process pc = 0x%x\n", pc)
-					if err = listFileLineByPtracePc(target.bi, 6); err != nil {
-						printErr(err)
-						return
-					}
-					return
-				}
-				if info, ok = bp.findBreakPoint(pc - 1); ok {
-					if err = bp.disableBreakPoint(pid, info); err != nil {
-						printErr(err)
-						return
-					}
-					defer bp.enableBreakPoint(pid, info)
-					if err = setPcRegister(target.cmd, pc-1); err != nil {
-						printErr(err)
-						return
-					}
-				}
-				if err = syscall.PtraceSingleStep(cmd.Process.Pid); err != nil {
-					printErr(err)
-					return
-				}
-				var s syscall.WaitStatus
-				if _, err = syscall.Wait4(cmd.Process.Pid, &s, syscall.WALL, nil); err != nil {
-					printErr(err)
-					return
-				}
-				if s.Exited() {
-					printExit0(cmd.Process.Pid)
-					cmd.Process = nil
-					return
-				}
-				if n := s.StopSignal(); n != syscall.SIGTRAP && n != syscall.SIGURG {
-					printErr(fmt.Errorf("unknown waitstatus %v, signal %d", s, s.Signal()))
-					return
+			if cmd.Process == nil {
+				printNoProcessErr()
+				return
+			}
+			var reason StopReason
+			for i := 0; i < count; i++ {
+				if reason, err = bp.nextInstruction(bi, pid); reason != StopDone || err != nil {
+					break
 				}
 			}
+			if !printStopReason(reason, err) {
+				return
+			}
+			if err = listInstructionByPtracePc(bi, bp, pid); err != nil {
+				printErr(err)
+				return
+			}
 			return
 		}
-	case 'n':
-		sps := strings.Split(input, " ")
-		if len(sps) == 1 && (sps[0] == "n" || sps[0] == "next") {
-			var (
-				err         error
-				pc          uint64
-				info        *BInfo
-				ok          bool
-				filename    string
-				lineno      int
-				oldfilename string
-				oldlineno   int
-
-				//f *Function
-				inst x86asm.Inst
-			)
-
-			if pc, err = getPtracePc(); err != nil {
-				printErr(err)
+		if len(sps) <= 2 && (sps[0] == "n" || sps[0] == "next") {
+			count, err := parseCount(sps)
+			if err != nil {
+				printUnsupportCmd(input)
 				return
 			}
-			if info, ok = bp.findBreakPoint(pc - 1); ok {
-				pc = pc - 1
-				if err = setPcRegister(target.cmd, pc); err != nil {
-					printErr(err)
-					return
-				}
-				if err = bp.disableBreakPoint(pid, info); err != nil {
-					printErr(err)
-					return
+			if cmd.Process == nil {
+				printNoProcessErr()
+				return
+			}
+			var reason StopReason
+			for i := 0; i < count; i++ {
+				if reason, err = bp.nextLine(bi, currentThread()); reason != StopDone || err != nil {
+					break
 				}
-				defer bp.enableBreakPoint(pid, info)
 			}
-			if oldfilename, oldlineno, err = bi.pcTofileLine(pc); err != nil {
-				printErr(err)
+			if !printStopReason(reason, err) {
 				return
 			}
-
-			calling := false
-			callingfpc := uint64(0)
-
-			for {
-				if pc, err = getPtracePc(); err != nil {
-					printErr(err)
-					return
-				}
-				if info, ok = bp.findBreakPoint(pc - 1); ok {
-					if err := listFileLineByPtracePc(target.bi, 6); err != nil {
-						printErr(err)
-						return
-					}
-					return
-				}
-
-				if calling == true && pc != callingfpc {
-					if err = syscall.PtraceSingleStep(cmd.Process.Pid); err != nil {
-						printErr(err)
-						return
-					}
-					var s syscall.WaitStatus
-					if _, err = syscall.Wait4(cmd.Process.Pid, &s, syscall.WALL, nil); err != nil {
-						printErr(err)
-						return
-					}
-					if s.Exited() {
-						printExit0(cmd.Process.Pid)
-						cmd.Process = nil
-						return
-					}
-					if n := s.StopSignal(); n != syscall.SIGTRAP && n != syscall.SIGURG {
-						printErr(fmt.Errorf("unknown waitstatus %v, signal %d", s, s.Signal()))
-						return
-					}
-				} else if calling == true && pc == callingfpc {
-					calling = false
-					if filename, lineno, err = bi.pcTofileLine(pc); err != nil {
-						printErr(err)
-						return
-					}
-					if !(filename == oldfilename && lineno == oldlineno) {
-						if err := listFileLineByPtracePc(target.bi, 6); err != nil {
-							printErr(err)
-							return
-						}
-						return
-					}
-				} else {
-					if inst, err = bi.getSingleMemInst(cmd.Process.Pid, pc); err != nil {
-						printErr(err)
-						return
-					}
-					if inst.Op == x86asm.CALL || inst.Op == x86asm.LCALL {
-						calling = true
-						callingfpc = pc + uint64(inst.Len)
-						continue
-					}
-
-					if err = syscall.PtraceSingleStep(cmd.Process.Pid); err != nil {
-						printErr(err)
-						return
-					}
-					var s syscall.WaitStatus
-					if _, err = syscall.Wait4(cmd.Process.Pid, &s, syscall.WALL, nil); err != nil {
-						printErr(err)
-						return
-					}
-					if s.Exited() {
-						printExit0(cmd.Process.Pid)
-						cmd.Process = nil
-						return
-					}
-					if n := s.StopSignal(); n != syscall.SIGTRAP && n != syscall.SIGURG {
-						printErr(fmt.Errorf("unknown waitstatus %v, signal %d", s, s.Signal()))
-						return
-					}
-					if filename, lineno, err = bi.pcTofileLine(pc + uint64(inst.Len)); err != nil {
-						printErr(err)
-						return
-					}
-					if !(filename == oldfilename && lineno == oldlineno) {
-						if err := listFileLineByPtracePc(target.bi, 6); err != nil {
-							printErr(err)
-							return
-						}
-						return
-					}
-				}
+			if err = listFileLineByPtracePc(target.bi, 6); err != nil {
+				printErr(err)
+				return
 			}
+			return
 		}
 	case 'l':
 		sps := strings.Split(input, " ")
 		if len(sps) == 1 && (sps[0] == "l" || sps[0] == "list") {
-			if err := listFileLineByPtracePc(target.bi, 6); err != nil {
+			sf, err := bi.selectedFrame(bp, pid)
+			if err != nil {
+				printErr(err)
+				return
+			}
+			filename, line, err := bi.location(sf)
+			if err != nil {
+				printErr(err)
+				return
+			}
+			if err = listFileLine(filename, line, 6); err != nil {
+				printErr(err)
+				return
+			}
+			return
+		}
+		if len(sps) == 1 && sps[0] == "locals" {
+			if err := printVariables(bi, bp, pid, dwarf.TagVariable); err != nil {
 				printErr(err)
 				return
 			}
@@ -491,21 +426,27 @@ func executor(input string) {
 		}
 	case 'r':
 		sps := strings.Split(input, " ")
-		if len(sps) == 1 && (sps[0] == "r" || sps[0] == "restart") {
-			pid := 0
-			if cmd.Process != nil {
-				pid = cmd.Process.Pid
-				if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGSTOP); err != nil {
-					err = nil
-					//printErr(err)
-					//return
+		if sps[0] == "r" || sps[0] == "restart" {
+			if target.attached {
+				printErr(RestartAttachedErr)
+				return
+			}
+			// the new arguments replace the old ones, `--` runs the program without arguments
+			if args := strings.TrimSpace(input[len(sps[0]):]); args == "--" {
+				target.args = nil
+			} else if args != "" {
+				var err error
+				if target.args, err = splitCommandArgs(args); err != nil {
+					printErr(err)
+					return
 				}
 			}
-			if pid != 0 {
-				fmt.Fprintf(stdout, "  stop  old process pid %d\n", pid)
+			if cmd.Process != nil {
+				killProcess(cmd.Process.Pid)
+				fmt.Fprintf(stdout, "  kill  old process pid %d\n", cmd.Process.Pid)
 			}
 			var err error
-			if cmd, err = runexec(target.execFile); err != nil {
+			if target.cmd, err = runexec(target.execFile); err != nil {
 				printErr(err)
 				logger.Error(err.Error(), zap.String("stage", "restart:runexec"), zap.String("execfile", target.execFile))
 				return
@@ -515,100 +456,261 @@ func executor(input string) {
 				logger.Error(err.Error(), zap.String("stage", "restart:setbp"), zap.String("execfile", target.execFile))
 				return
 			}
-			fmt.Fprintf(stdout, "restart new process pid %d \n", cmd.Process.Pid)
+			target.frame = 0
+			// the history of the old process is useless
+			if target.record.isRecording() {
+				target.record.Start(len(target.record.entries))
+			}
+			fmt.Fprintf(stdout, "restart new process pid %d \n", target.cmd.Process.Pid)
+			return
+		}
+		if len(sps) <= 2 && sps[0] == "record" {
+			if cmd.Process == nil {
+				printNoProcessErr()
+				return
+			}
+			if len(sps) == 2 && sps[1] == "stop" {
+				target.record.Stop()
+				fmt.Fprintf(stdout, "record stopped\n")
+				return
+			}
+			size := defaultRecordSize
+			if len(sps) == 2 {
+				var err error
+				if size, err = strconv.Atoi(sps[1]); err != nil || size <= 0 {
+					printUnsupportCmd(input)
+					return
+				}
+			}
+			target.record.Start(size)
+			fmt.Fprintf(stdout, "record started, keep the newest %d instructions\n", size)
+			return
+		}
+		if len(sps) == 1 && (sps[0] == "rsi" || sps[0] == "reverse-stepi") {
+			if cmd.Process == nil {
+				printNoProcessErr()
+				return
+			}
+			if !target.record.isRecording() {
+				printErr(NotRecordingErr)
+				return
+			}
+			if err := target.record.reverseStepInstruction(bp, pid); err != nil {
+				printErr(err)
+				if err != NoRecordHistoryErr {
+					return
+				}
+			}
+			if err := listInstructionByPtracePc(bi, bp, pid); err != nil {
+				printErr(err)
+				return
+			}
+			return
+		}
+		if len(sps) == 1 && (sps[0] == "rn" || sps[0] == "reverse-next") {
+			if cmd.Process == nil {
+				printNoProcessErr()
+				return
+			}
+			if !target.record.isRecording() {
+				printErr(NotRecordingErr)
+				return
+			}
+			if err := target.record.reverseNextLine(bi, bp, pid); err != nil {
+				printErr(err)
+				if err != NoRecordHistoryErr {
+					return
+				}
+			}
+			if err := listFileLineByPtracePc(target.bi, 6); err != nil {
+				printErr(err)
+				return
+			}
+			return
+		}
+		if len(sps) == 1 && (sps[0] == "rc" || sps[0] == "reverse-continue") {
+			if cmd.Process == nil {
+				printNoProcessErr()
+				return
+			}
+			if !target.record.isRecording() {
+				printErr(NotRecordingErr)
+				return
+			}
+			if err := target.record.reverseContinue(bp, pid); err != nil {
+				printErr(err)
+				if err != NoRecordHistoryErr {
+					return
+				}
+			}
+			pc, err := getPtracePc()
+			if err != nil {
+				printErr(err)
+				return
+			}
+			fmt.Fprintf(stdout, "current process pc = 0x%x\n", pc)
+			if err = listFileLineByPtracePc(target.bi, 6); err != nil {
+				printErr(err)
+				return
+			}
 			return
 		}
 	case 'd':
 		sps := strings.Split(input, " ")
 		if len(sps) == 1 && (sps[0] == "disass" || sps[0] == "disassemble") {
-			if err := listDisassembleByPtracePc(target.bi, target.bp, target.cmd.Process.Pid); err != nil {
+			sf, err := bi.selectedFrame(bp, pid)
+			if err != nil {
+				printErr(err)
+				return
+			}
+			if err = listDisassembleByPc(bi, bp, pid, sf.lookupPc()); err != nil {
+				printErr(err)
+				return
+			}
+			return
+		}
+		if len(sps) <= 2 && sps[0] == "down" {
+			count, err := parseCount(sps)
+			if err != nil {
+				printUnsupportCmd(input)
+				return
+			}
+			if err = bi.selectFrame(bp, pid, target.frame-count); err != nil {
+				printErr(err)
+				return
+			}
+			return
+		}
+		if len(sps) == 2 && sps[0] == "dump" {
+			if cmd.Process == nil {
+				printNoProcessErr()
+				return
+			}
+			if err := writeCore(bp, cmd.Process.Pid, sps[1]); err != nil {
 				printErr(err)
 				return
 			}
+			fmt.Fprintf(stdout, "dump the core of process %d into %s\n", cmd.Process.Pid, sps[1])
+			return
+		}
+		if len(sps) == 1 && sps[0] == "detach" {
+			if cmd.Process == nil {
+				printNoProcessErr()
+				return
+			}
+			opid := cmd.Process.Pid
+			if err := detach(bp); err != nil {
+				printErr(err)
+				return
+			}
+			cmd.Process = nil
+			fmt.Fprintf(stdout, "detach process %d, all breakpoints are removed\n", opid)
 			return
 		}
 	case 'p':
 		sps := strings.Split(input, " ")
 		if len(sps) == 2 && (sps[0] == "p" || sps[0] == "print") {
 			var (
-				v     string
-				pc    uint64
-				err   error
-				ok    bool
-				f     *Function
-				frame *Frame
+				v   string
+				err error
+				sf  *Stackframe
+				val string
 			)
 			v = sps[1]
-			if pc, err = getPtracePc(); err != nil {
+			if sf, err = bi.selectedFrame(bp, pid); err != nil {
 				printErr(err)
 				return
 			}
-			if _, ok = bp.findBreakPoint(pc - 1); ok {
-				pc--
+			if sf.fn == nil {
+				printErr(&NotFoundFuncErr{pc: sf.lookupPc()})
+				return
+			}
+			for _, fv := range sf.fn.variables {
+				if variableName(fv) == v {
+					if val, err = bi.readVariable(pid, fv, sf); err != nil {
+						printErr(err)
+						return
+					}
+					fmt.Fprintf(stdout, "%v\n", val)
+					return
+				}
 			}
-			if frame, err = bi.findFrameInformation(pc); err != nil {
+			printErr(&NotFoundVariableErr{name: v})
+			return
+		}
+	case 'a':
+		sps := strings.Split(input, " ")
+		if len(sps) == 1 && sps[0] == "args" {
+			if err := printVariables(bi, bp, pid, dwarf.TagFormalParameter); err != nil {
 				printErr(err)
 				return
 			}
-			if f, err = bi.findFunctionIncludePc(pc); err != nil {
+			return
+		}
+	case 'f':
+		sps := strings.Split(input, " ")
+		if len(sps) == 2 && (sps[0] == "f" || sps[0] == "frame") {
+			index, err := strconv.Atoi(sps[1])
+			if err != nil {
+				printUnsupportCmd(input)
+				return
+			}
+			if err = bi.selectFrame(bp, pid, index); err != nil {
 				printErr(err)
 				return
 			}
-			for _, fv := range f.variables {
-				isFound := false
-				if field := fv.AttrField(dwarf.AttrName); field != nil {
-					if fieldstr, ok := field.Val.(string); ok && fieldstr == v {
-						isFound = true
-					}
-				}
-				if isFound {
-					var (
-						opcode byte
-					)
-					field := fv.AttrField(dwarf.AttrLocation)
-					buf := bytes.NewBuffer(field.Val.([]byte))
-					if opcode, err = buf.ReadByte(); err != nil {
-						printErr(err)
-						return
-					}
-					switch opcode {
-					case DW_OP_fbreg:
-						num, _, _ := DecodeSLEB128(buf)
-						address := int64(frame.framebase) + num
-						// if the type is `string`
-						val := make([]byte, 8)
-						if _, err = syscall.PtracePeekData(cmd.Process.Pid, uintptr(address)+uintptr(8), val); err != nil {
-							printErr(err)
-							return
-						}
-						strlen := int64(binary.LittleEndian.Uint64(val))
-						if strlen < 0 {
-							printErr(fmt.Errorf("strlen %d shoulde be < 0", strlen))
-							return
-						}
-						// read addr
-						if _, err = syscall.PtracePeekData(cmd.Process.Pid, uintptr(address), val); err != nil {
-							printErr(err)
-							return
-						}
-						addr := uintptr(binary.LittleEndian.Uint64(val))
-						if addr == 0 {
-							printErr(fmt.Errorf("pointer addr %d shoulde be == 0", addr))
-							return
-						}
-						logger.Debug(fmt.Sprintf("address = %d,  len = %d, addr = %d,, num = %d\n", address, strlen, addr, num))
-
-						strpointer := make([]byte, strlen)
-						if _, err = syscall.PtracePeekData(cmd.Process.Pid, uintptr(addr), strpointer); err != nil {
-							printErr(err)
-							return
-						}
-						fmt.Fprintf(stdout, "%v\n", *(*string)(unsafe.Pointer(&strpointer)))
-						return
-					}
-					fmt.Fprintf(stderr, "not support dwarf variable %#v", fv)
+			return
+		}
+	case 'u':
+		sps := strings.Split(input, " ")
+		if len(sps) <= 2 && sps[0] == "up" {
+			count, err := parseCount(sps)
+			if err != nil {
+				printUnsupportCmd(input)
+				return
+			}
+			if err = bi.selectFrame(bp, pid, target.frame+count); err != nil {
+				printErr(err)
+				return
+			}
+			return
+		}
+	case 'g':
+		sps := strings.Split(input, " ")
+		if len(sps) == 1 && sps[0] == "goroutines" {
+			if cmd.Process == nil {
+				printNoProcessErr()
+				return
+			}
+			if err := bi.printGoroutines(pid); err != nil {
+				printErr(err)
+				return
+			}
+			return
+		}
+	case 'x':
+		sps := strings.Split(input, " ")
+		if len(sps) <= 3 && len(sps) >= 2 && sps[0] == "x" {
+			if cmd.Process == nil {
+				printNoProcessErr()
+				return
+			}
+			addr, err := strconv.ParseUint(sps[1], 0, 64)
+			if err != nil {
+				printUnsupportCmd(input)
+				return
+			}
+			count := 16
+			if len(sps) == 3 {
+				if count, err = parseCount(sps[1:]); err != nil {
+					printUnsupportCmd(input)
+					return
 				}
 			}
+			if err = examineMemory(bp, pid, addr, count); err != nil {
+				printErr(err)
+				return
+			}
 			return
 		}
 	case 'h':
@@ -617,6 +719,23 @@ func executor(input string) {
 			printCmdHelper()
 			return
 		}
+		if len(sps) == 1 && sps[0] == "handle" {
+			printSignalTable(target.signals, 0)
+			return
+		}
+		if len(sps) >= 2 && sps[0] == "handle" {
+			sig, err := parseSignal(sps[1])
+			if err != nil {
+				printErr(err)
+				return
+			}
+			if err = target.signals.Handle(sig, sps[2:]); err != nil {
+				printErr(err)
+				return
+			}
+			printSignalTable(target.signals, sig)
+			return
+		}
 	}
 	printUnsupportCmd(input)
 }
@@ -665,6 +784,39 @@ func complete(docs prompt.Document) []prompt.Suggest {
 	return s
 }
 
+// parseCount parses the optional repeat count of stepping and continue commands, like `n 5`.
+func parseCount(sps []string) (int, error) {
+	if len(sps) < 2 {
+		return 1, nil
+	}
+	count, err := strconv.Atoi(sps[1])
+	if err != nil {
+		return 0, err
+	}
+	if count <= 0 {
+		return 0, fmt.Errorf("count %d should be > 0", count)
+	}
+	return count, nil
+}
+
+// printStopReason reports why a stepping or continue command stopped early,
+// it returns false if the current location can't be shown.
+func printStopReason(reason StopReason, err error) bool {
+	if reason == StopKilled {
+		target.cmd.Process = nil
+	}
+	if err != nil {
+		printErr(err)
+		return false
+	}
+	if reason == StopExited {
+		printExit0(target.cmd.Process.Pid)
+		target.cmd.Process = nil
+		return false
+	}
+	return true
+}
+
 const (
 	_AT_NULL_AMD64  = 0
 	_AT_ENTRY_AMD64 = 9
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"golang.org/x/arch/x86/x86asm"
+	"syscall"
+	"unsafe"
+)
+
+const defaultRecordSize = 100000
+
+const ptraceRegsNum = int(unsafe.Sizeof(syscall.PtraceRegs{}) / 8)
+
+// RegDelta is the value of a register before it was changed by an instruction.
+type RegDelta struct {
+	index    uint8 // the index of field in syscall.PtraceRegs
+	original uint64
+}
+
+// MemDelta is the memory before it was written by an instruction.
+type MemDelta struct {
+	addr     uint64
+	original []byte
+}
+
+// RecordEntry is the undo information of a single executed instruction.
+type RecordEntry struct {
+	pc   uint64
+	sp   uint64
+	regs []RegDelta
+	mems []MemDelta
+	// the x87, MMX and SSE registers before the instruction, if it changed them
+	fpregs []byte
+}
+
+// Recorder keeps the newest executed instructions in a ring buffer,
+// so that the tracee can be executed backward.
+type Recorder struct {
+	enabled bool
+	entries []*RecordEntry
+	start   int
+	size    int
+}
+
+func (r *Recorder) isRecording() bool {
+	return r != nil && r.enabled
+}
+
+func (r *Recorder) Start(size int) {
+	r.enabled = true
+	r.entries = make([]*RecordEntry, size)
+	r.start = 0
+	r.size = 0
+}
+
+func (r *Recorder) Stop() {
+	r.enabled = false
+	r.entries = nil
+	r.start = 0
+	r.size = 0
+}
+
+func (r *Recorder) Len() int {
+	if r == nil {
+		return 0
+	}
+	return r.size
+}
+
+func (r *Recorder) push(entry *RecordEntry) {
+	if len(r.entries) == 0 {
+		return
+	}
+	if r.size == len(r.entries) {
+		// overwrite the oldest one
+		r.entries[r.start] = entry
+		r.start = (r.start + 1) % len(r.entries)
+		return
+	}
+	r.entries[(r.start+r.size)%len(r.entries)] = entry
+	r.size++
+}
+
+func (r *Recorder) last() *RecordEntry {
+	if r.Len() == 0 {
+		return nil
+	}
+	return r.entries[(r.start+r.size-1)%len(r.entries)]
+}
+
+func (r *Recorder) pop() *RecordEntry {
+	entry := r.last()
+	if entry == nil {
+		return nil
+	}
+	r.entries[(r.start+r.size-1)%len(r.entries)] = nil
+	r.size--
+	return entry
+}
+
+func ptraceRegsSlice(regs *syscall.PtraceRegs) []uint64 {
+	return (*[ptraceRegsNum]uint64)(unsafe.Pointer(regs))[:]
+}
+
+// recordMemWrites saves the memory which may be written by inst.
+// The destinations are decoded from the memory operands and the implicit stack or string operations.
+func recordMemWrites(pid int, regs *syscall.PtraceRegs, pc uint64, inst x86asm.Inst) []MemDelta {
+	type memRange struct {
+		addr uint64
+		size uint64
+	}
+	ranges := make([]memRange, 0, 1)
+
+	for _, arg := range inst.Args {
+		if arg == nil {
+			break
+		}
+		mem, ok := arg.(x86asm.Mem)
+		if !ok {
+			continue
+		}
+		addr, ok := memAddress(regs, pc, inst, mem)
+		if !ok {
+			continue
+		}
+		size := uint64(inst.MemBytes)
+		if size == 0 {
+			size = 8
+		}
+		ranges = append(ranges, memRange{addr: addr, size: size})
+	}
+
+	switch inst.Op {
+	case x86asm.PUSH, x86asm.PUSHF, x86asm.PUSHFQ, x86asm.CALL, x86asm.LCALL:
+		ranges = append(ranges, memRange{addr: regs.Rsp - 8, size: 8})
+	case x86asm.STOSB, x86asm.STOSW, x86asm.STOSD, x86asm.STOSQ,
+		x86asm.MOVSB, x86asm.MOVSW, x86asm.MOVSD, x86asm.MOVSQ:
+		// a single step of REP executes one iteration, which writes one element at rdi,
+		// then rdi moves forward, or backward if DF is set
+		size := uint64(inst.MemBytes)
+		if size == 0 {
+			size = uint64(inst.DataSize / 8)
+		}
+		ranges = append(ranges, memRange{addr: regs.Rdi, size: size})
+	}
+
+	mems := make([]MemDelta, 0, len(ranges))
+	for _, rg := range ranges {
+		if rg.size == 0 {
+			continue
+		}
+		original := make([]byte, rg.size)
+		// the instruction will fault if the address is invalid, there is nothing to undo
+		if _, err := currentProcess().ReadMemory(pid, rg.addr, original); err != nil {
+			continue
+		}
+		mems = append(mems, MemDelta{addr: rg.addr, original: original})
+	}
+	return mems
+}
+
+// usesFPRegisters reports whether inst may change the x87, MMX or SSE registers, which aren't in PtraceRegs.
+func usesFPRegisters(inst x86asm.Inst) bool {
+	switch inst.Op {
+	case x86asm.FXRSTOR, x86asm.FXRSTOR64, x86asm.XRSTOR, x86asm.XRSTOR64, x86asm.LDMXCSR:
+		return true
+	}
+	for _, arg := range inst.Args {
+		if arg == nil {
+			break
+		}
+		if reg, ok := arg.(x86asm.Reg); ok && x86asm.F0 <= reg && reg <= x86asm.X15 {
+			return true
+		}
+	}
+	return false
+}
+
+// memAddress computes the effective address of a memory operand.
+func memAddress(regs *syscall.PtraceRegs, pc uint64, inst x86asm.Inst, mem x86asm.Mem) (uint64, bool) {
+	var addr uint64
+
+	switch mem.Segment {
+	case x86asm.FS:
+		addr += regs.Fs_base
+	case x86asm.GS:
+		addr += regs.Gs_base
+	}
+	if mem.Base == x86asm.RIP {
+		addr += pc + uint64(inst.Len)
+	} else if mem.Base != 0 {
+		base, ok := registerValue(regs, mem.Base)
+		if !ok {
+			return 0, false
+		}
+		addr += base
+	}
+	if mem.Index != 0 {
+		index, ok := registerValue(regs, mem.Index)
+		if !ok {
+			return 0, false
+		}
+		addr += index * uint64(mem.Scale)
+	}
+	return addr + uint64(mem.Disp), true
+}
+
+// recordSingleStep executes one instruction like PtraceSingleStep, and pushes the undo information into the recorder.
+func (r *Recorder) recordSingleStep(bp *BP, pid int) (syscall.WaitStatus, error) {
+	var (
+		s        syscall.WaitStatus
+		before   syscall.PtraceRegs
+		after    syscall.PtraceRegs
+		fpBefore []byte
+		fpAfter  []byte
+		inst     x86asm.Inst
+		err      error
+	)
+	if before, err = currentProcess().Registers(pid); err != nil {
+		return s, err
+	}
+	entry := &RecordEntry{pc: before.PC(), sp: before.Rsp}
+	if inst, err = bp.getSingleMemInst(pid, before.PC()); err == nil {
+		entry.mems = recordMemWrites(pid, &before, before.PC(), inst)
+		if usesFPRegisters(inst) {
+			if fpBefore, err = currentProcess().FPRegisters(pid); err != nil {
+				return s, err
+			}
+		}
+	}
+
+	if err = currentProcess().SingleStep(pid); err != nil {
+		return s, err
+	}
+	if s, err = currentProcess().Wait(pid); err != nil {
+		return s, err
+	}
+	if s.Exited() {
+		return s, nil
+	}
+	if after, err = currentProcess().Registers(pid); err != nil {
+		return s, err
+	}
+
+	beforeSlice, afterSlice := ptraceRegsSlice(&before), ptraceRegsSlice(&after)
+	for i := range beforeSlice {
+		if beforeSlice[i] != afterSlice[i] {
+			entry.regs = append(entry.regs, RegDelta{index: uint8(i), original: beforeSlice[i]})
+		}
+	}
+	if fpBefore != nil {
+		if fpAfter, err = currentProcess().FPRegisters(pid); err != nil {
+			return s, err
+		}
+		if !bytes.Equal(fpBefore, fpAfter) {
+			entry.fpregs = fpBefore
+		}
+	}
+	r.push(entry)
+	return s, nil
+}
+
+// undo rewinds the newest recorded instruction.
+func (r *Recorder) undo(pid int) (*RecordEntry, error) {
+	var (
+		regs syscall.PtraceRegs
+		err  error
+	)
+	entry := r.pop()
+	if entry == nil {
+		return nil, NoRecordHistoryErr
+	}
+	for i := len(entry.mems) - 1; i >= 0; i-- {
+		if _, err = currentProcess().WriteMemory(pid, entry.mems[i].addr, entry.mems[i].original); err != nil {
+			return nil, err
+		}
+	}
+	if regs, err = currentProcess().Registers(pid); err != nil {
+		return nil, err
+	}
+	regsSlice := ptraceRegsSlice(&regs)
+	for _, delta := range entry.regs {
+		regsSlice[delta.index] = delta.original
+	}
+	if entry.fpregs != nil {
+		if err = currentProcess().SetFPRegisters(pid, entry.fpregs); err != nil {
+			return nil, err
+		}
+	}
+	return entry, currentProcess().SetRegisters(pid, &regs)
+}
+
+// rewindBreakPointTrap moves the pc back to the breakpoint which the tracee has just trapped on,
+// so the next undo starts from the instruction address.
+func (r *Recorder) rewindBreakPointTrap(bp *BP) error {
+	pc, err := getPtracePc()
+	if err != nil {
+		return err
+	}
+	if _, ok := bp.findBreakPoint(pc - 1); ok {
+		return setPcRegister(target.cmd, pc-1)
+	}
+	return nil
+}
+
+// reverseStepInstruction executes one instruction backward.
+func (r *Recorder) reverseStepInstruction(bp *BP, pid int) error {
+	if err := r.rewindBreakPointTrap(bp); err != nil {
+		return err
+	}
+	_, err := r.undo(pid)
+	return err
+}
+
+// reverseNextLine executes backward to the beginning of the previous source line, calls are stepped over.
+func (r *Recorder) reverseNextLine(bi *BI, bp *BP, pid int) error {
+	var (
+		regs        syscall.PtraceRegs
+		err         error
+		entry       *RecordEntry
+		filename    string
+		lineno      int
+		oldfilename string
+		oldlineno   int
+	)
+	if err = r.rewindBreakPointTrap(bp); err != nil {
+		return err
+	}
+	if regs, err = getRegisters(target.cmd); err != nil {
+		return err
+	}
+	if oldfilename, oldlineno, err = bi.pcTofileLine(regs.PC()); err != nil {
+		return err
+	}
+	sp := regs.Rsp
+
+	// leave the current line, skipping the instructions of callees whose stack is below the current frame
+	for {
+		if entry, err = r.undo(pid); err != nil {
+			return err
+		}
+		if entry.sp < sp {
+			continue
+		}
+		if filename, lineno, err = bi.pcTofileLine(entry.pc); err != nil {
+			return err
+		}
+		if !(filename == oldfilename && lineno == oldlineno) {
+			break
+		}
+	}
+
+	// go to the beginning of the previous line
+	sp = entry.sp
+	for {
+		if entry = r.last(); entry == nil {
+			return nil
+		}
+		if entry.sp >= sp {
+			var (
+				prevfilename string
+				prevlineno   int
+			)
+			if prevfilename, prevlineno, err = bi.pcTofileLine(entry.pc); err != nil {
+				return err
+			}
+			if !(prevfilename == filename && prevlineno == lineno) {
+				return nil
+			}
+		}
+		if _, err = r.undo(pid); err != nil {
+			return err
+		}
+	}
+}
+
+// reverseContinue executes backward until a user breakpoint is reached or the history is exhausted.
+func (r *Recorder) reverseContinue(bp *BP, pid int) error {
+	var (
+		entry *RecordEntry
+		info  *BInfo
+		ok    bool
+		err   error
+	)
+	if err = r.rewindBreakPointTrap(bp); err != nil {
+		return err
+	}
+	for {
+		if entry, err = r.undo(pid); err != nil {
+			return err
+		}
+		if info, ok = bp.findBreakPoint(entry.pc); ok && info.kind == USERBPTYPE {
+			return nil
+		}
+	}
+}
@@ -1,17 +1,22 @@
 package main
 
 import (
+	"golang.org/x/arch/x86/x86asm"
 	"os/exec"
 	"syscall"
 )
 
+// the size of user_fpregs_struct of amd64, which is the layout of FXSAVE
+const fpregsSize = 512
+
+// the offset of xmm0 in user_fpregs_struct, every xmm register is 16 bytes
+const fpregsXmmOffset = 160
+
 func getRegisters(cmd *exec.Cmd) (syscall.PtraceRegs, error) {
-	var prs syscall.PtraceRegs
-	if cmd.Process == nil {
-		return prs, NoProcessRuning
+	if target.process == nil && cmd.Process == nil {
+		return syscall.PtraceRegs{}, NoProcessRuning
 	}
-	err := syscall.PtraceGetRegs(cmd.Process.Pid, &prs)
-	return prs, err
+	return currentProcess().Registers(currentThread())
 }
 
 func getPtracePc() (uint64, error) {
@@ -34,7 +39,7 @@ func setPcRegister(cmd *exec.Cmd, pc uint64) error {
 		return err
 	}
 	prs.SetPC(pc)
-	return syscall.PtraceSetRegs(cmd.Process.Pid, &prs)
+	return currentProcess().SetRegisters(currentThread(), &prs)
 }
 
 func getPtraceBp() (uint64, error) {
@@ -48,3 +53,20 @@ func getPtraceBp() (uint64, error) {
 	return prs.Rbp, nil
 
 }
+
+// registerValue returns the value of a general purpose register which is decoded by x86asm.
+func registerValue(regs *syscall.PtraceRegs, reg x86asm.Reg) (uint64, bool) {
+	gprs := [16]uint64{
+		regs.Rax, regs.Rcx, regs.Rdx, regs.Rbx, regs.Rsp, regs.Rbp, regs.Rsi, regs.Rdi,
+		regs.R8, regs.R9, regs.R10, regs.R11, regs.R12, regs.R13, regs.R14, regs.R15,
+	}
+	switch {
+	case x86asm.RAX <= reg && reg <= x86asm.R15:
+		return gprs[reg-x86asm.RAX], true
+	case x86asm.EAX <= reg && reg <= x86asm.R15L:
+		return gprs[reg-x86asm.EAX] & 0xffffffff, true
+	case reg == x86asm.RIP:
+		return regs.Rip, true
+	}
+	return 0, false
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// the names of the signals of linux amd64
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP: "SIGHUP", syscall.SIGINT: "SIGINT", syscall.SIGQUIT: "SIGQUIT", syscall.SIGILL: "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP", syscall.SIGABRT: "SIGABRT", syscall.SIGBUS: "SIGBUS", syscall.SIGFPE: "SIGFPE",
+	syscall.SIGKILL: "SIGKILL", syscall.SIGUSR1: "SIGUSR1", syscall.SIGSEGV: "SIGSEGV", syscall.SIGUSR2: "SIGUSR2",
+	syscall.SIGPIPE: "SIGPIPE", syscall.SIGALRM: "SIGALRM", syscall.SIGTERM: "SIGTERM", syscall.SIGSTKFLT: "SIGSTKFLT",
+	syscall.SIGCHLD: "SIGCHLD", syscall.SIGCONT: "SIGCONT", syscall.SIGSTOP: "SIGSTOP", syscall.SIGTSTP: "SIGTSTP",
+	syscall.SIGTTIN: "SIGTTIN", syscall.SIGTTOU: "SIGTTOU", syscall.SIGURG: "SIGURG", syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ", syscall.SIGVTALRM: "SIGVTALRM", syscall.SIGPROF: "SIGPROF", syscall.SIGWINCH: "SIGWINCH",
+	syscall.SIGIO: "SIGIO", syscall.SIGPWR: "SIGPWR", syscall.SIGSYS: "SIGSYS",
+}
+
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return fmt.Sprintf("SIG%d", int(sig))
+}
+
+// parseSignal parses the signal like `SIGUSR1`, `USR1`, `10` or `SIG34`, which is printed for the signal without name.
+func parseSignal(s string) (syscall.Signal, error) {
+	name := strings.ToUpper(s)
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "SIG")); err == nil && n > 0 && n < 65 {
+		return syscall.Signal(n), nil
+	}
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for sig, v := range signalNames {
+		if v == name {
+			return sig, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown signal `%s`", s)
+}
+
+// SignalPolicy is how a signal which the process receives is handled, like `handle` of gdb.
+type SignalPolicy struct {
+	stop  bool // stop the process, and give the control to the user
+	print bool // print the signal when it is received
+	pass  bool // deliver the signal to the process when it is continued
+}
+
+// SignalTable holds the policies of the signals which are changed by `handle`, the others stop the process.
+// SIGTRAP and SIGSTOP are used by the debugger itself, so they can't be handled.
+type SignalTable struct {
+	policies map[syscall.Signal]*SignalPolicy
+	pending  map[int]syscall.Signal // the signals which are delivered to the threads when they are continued
+}
+
+func newSignalTable() *SignalTable {
+	quiet := func() *SignalPolicy { return &SignalPolicy{pass: true} }
+	return &SignalTable{
+		policies: map[syscall.Signal]*SignalPolicy{
+			// the preemption of goroutines, and the signals which the runtime uses often
+			syscall.SIGURG:    quiet(),
+			syscall.SIGCHLD:   quiet(),
+			syscall.SIGWINCH:  quiet(),
+			syscall.SIGPROF:   quiet(),
+			syscall.SIGALRM:   quiet(),
+			syscall.SIGVTALRM: quiet(),
+			syscall.SIGPIPE:   quiet(),
+			// the interrupt of the terminal is for the debugger
+			syscall.SIGINT: {stop: true, print: true},
+			// the stop of the process is the stop in the debugger, the stopped thread would never be resumed
+			syscall.SIGSTOP: {stop: true, print: true},
+		},
+		pending: make(map[int]syscall.Signal),
+	}
+}
+
+func (st *SignalTable) policy(sig syscall.Signal) *SignalPolicy {
+	if policy, ok := st.policies[sig]; ok {
+		return policy
+	}
+	return &SignalPolicy{stop: true, print: true, pass: true}
+}
+
+// Handle changes the policy of the signal by the keywords `stop`, `nostop`, `print`, `noprint`, `pass` and `nopass`.
+// Like gdb, `stop` implies `print`, and `noprint` implies `nostop`.
+func (st *SignalTable) Handle(sig syscall.Signal, keywords []string) error {
+	if sig == syscall.SIGTRAP || sig == syscall.SIGSTOP || sig == syscall.SIGKILL {
+		return fmt.Errorf("%s is used by the debugger, it can't be handled", signalName(sig))
+	}
+	policy := *st.policy(sig)
+	for _, keyword := range keywords {
+		switch keyword {
+		case "stop":
+			policy.stop, policy.print = true, true
+		case "nostop":
+			policy.stop = false
+		case "print":
+			policy.print = true
+		case "noprint":
+			policy.print, policy.stop = false, false
+		case "pass":
+			policy.pass = true
+		case "nopass":
+			policy.pass = false
+		default:
+			return fmt.Errorf("unknown keyword `%s`, please use stop|nostop|print|noprint|pass|nopass", keyword)
+		}
+	}
+	st.policies[sig] = &policy
+	return nil
+}
+
+// received applies the policy to the signal which the thread tid receives, it reports whether the process stops.
+// The signal which is passed is pending until the thread is continued.
+func (st *SignalTable) received(tid int, sig syscall.Signal) bool {
+	policy := st.policy(sig)
+	if policy.print {
+		fmt.Fprintf(stdout, "thread %d received signal %s\n", tid, signalName(sig))
+	}
+	if policy.pass {
+		st.pending[tid] = sig
+	}
+	return policy.stop
+}
+
+// takePending returns the signal which is delivered to the thread tid when it is continued.
+func (st *SignalTable) takePending(tid int) int {
+	sig, ok := st.pending[tid]
+	if !ok {
+		return 0
+	}
+	delete(st.pending, tid)
+	return int(sig)
+}
+
+func (st *SignalTable) clearPending() {
+	st.pending = make(map[int]syscall.Signal)
+}
+
+// printSignalTable prints the policies of sig, or all the signals if sig is 0.
+func printSignalTable(st *SignalTable, sig syscall.Signal) {
+	yesNo := func(b bool) string {
+		if b {
+			return "Yes"
+		}
+		return "No"
+	}
+	// the named signals, and the others whose policies are changed, like the realtime signals
+	listed := make(map[syscall.Signal]bool, len(signalNames)+len(st.policies))
+	for s := range signalNames {
+		listed[s] = true
+	}
+	for s := range st.policies {
+		listed[s] = true
+	}
+	sigs := make([]int, 0, len(listed))
+	for s := range listed {
+		if (sig == 0 || s == sig) && s != syscall.SIGTRAP && s != syscall.SIGSTOP && s != syscall.SIGKILL {
+			sigs = append(sigs, int(s))
+		}
+	}
+	sort.Ints(sigs)
+	fmt.Fprintf(stdout, "%-10s %-5s %-5s %-5s\n", "Signal", "Stop", "Print", "Pass")
+	for _, s := range sigs {
+		policy := st.policy(syscall.Signal(s))
+		fmt.Fprintf(stdout, "%-10s %-5s %-5s %-5s\n", signalName(syscall.Signal(s)),
+			yesNo(policy.stop), yesNo(policy.print), yesNo(policy.pass))
+	}
+}
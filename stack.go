@@ -0,0 +1,380 @@
+package main
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+	"syscall"
+)
+
+const maxStackDepth = 1024
+
+// DWARF register numbers of amd64
+const (
+	dwarfRegRbp = 6
+	dwarfRegRsp = 7
+	dwarfRegPc  = 16
+
+	dwarfRegXmm0  = 17
+	dwarfRegXmm15 = 32
+)
+
+// Stackframe is a frame of the call stack which is computed by the unwinder.
+type Stackframe struct {
+	pc   uint64   // the return address for the caller frames
+	cfa  uint64   // the value of rsp in the caller before `CALL`
+	regs []uint64 // DWARF numbered registers in this frame
+	ret  uint64   // the return address of this frame
+	fn   *Function
+	call bool // pc is a return address, so pc-1 is in the `CALL` of this frame
+	fp   bool // unwound by frame pointer because no fde covers pc
+
+	// the frame of the signal trampoline, the registers of the caller are saved by the kernel,
+	// and the caller is interrupted at ret instead of calling
+	signal bool
+
+	inlined bool // the frame of an inlined call, which shares the registers and the cfa with its caller
+
+	// a caller frame, its registers except rsp, rbp and pc may be lost by unwinding, go doesn't save them
+	outer bool
+
+	// the location of the frame, if it isn't the line of pc, like the caller of an inlined call
+	filename string
+	line     int
+}
+
+// lookupPc is the address which is used to look up the line, the function and the fde of this frame.
+func (sf *Stackframe) lookupPc() uint64 {
+	if sf.call {
+		return sf.pc - 1
+	}
+	return sf.pc
+}
+
+func readUint64(pid int, addr uint64) (uint64, error) {
+	buf := make([]byte, 8)
+	if _, err := readMemory(pid, addr, buf); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// stacktrace unwinds the call stack of the tracee from the current registers, at most depth frames.
+func (bi *BI) stacktrace(bp *BP, pid int, depth int) ([]*Stackframe, error) {
+	var (
+		regs syscall.PtraceRegs
+		err  error
+		ok   bool
+
+		callerRegs []uint64
+	)
+	if regs, err = getRegisters(target.cmd); err != nil {
+		return nil, err
+	}
+
+	dwarfRegs := make([]uint64, 17)
+	dwarfRegs[dwarfRegPc] = regs.PC()
+	dwarfRegs[dwarfRegRsp] = regs.Rsp
+	dwarfRegs[dwarfRegRbp] = regs.Rbp
+	dwarfRegs[0] = regs.Rax
+	dwarfRegs[1] = regs.Rdx
+	dwarfRegs[2] = regs.Rcx
+	dwarfRegs[3] = regs.Rbx
+	dwarfRegs[4] = regs.Rsi
+	dwarfRegs[5] = regs.Rdi
+	dwarfRegs[8] = regs.R8
+	dwarfRegs[9] = regs.R9
+	dwarfRegs[10] = regs.R10
+	dwarfRegs[11] = regs.R11
+	dwarfRegs[12] = regs.R12
+	dwarfRegs[13] = regs.R13
+	dwarfRegs[14] = regs.R14
+	dwarfRegs[15] = regs.R15
+	if _, ok = bp.findBreakPoint(regs.PC() - 1); ok {
+		dwarfRegs[dwarfRegPc] = regs.PC() - 1
+	}
+
+	sf := &Stackframe{pc: dwarfRegs[dwarfRegPc], regs: dwarfRegs}
+	frames := make([]*Stackframe, 0, 8)
+	for len(frames) < depth {
+		if callerRegs, err = bi.unwindFrame(pid, sf); err != nil {
+			if len(frames) == 0 {
+				return nil, err
+			}
+			logger.Debug("stacktrace", zap.Error(err), zap.Uint64("pc", sf.pc))
+			break
+		}
+		frames = append(frames, bi.inlinedFrames(sf)...)
+		frames = append(frames, sf)
+		if sf.ret == 0 || (sf.fn != nil && isOutermostFunction(sf.fn.name)) {
+			break
+		}
+
+		sf = &Stackframe{pc: sf.ret, regs: callerRegs, call: !sf.signal, outer: true}
+	}
+	if len(frames) > depth {
+		frames = frames[:depth]
+	}
+	return frames, nil
+}
+
+// inlinedFrames returns the frames of the inlined calls which contain the pc of sf, the innermost one is the first.
+// The location of sf is changed to the call site of the outermost inlined call.
+func (bi *BI) inlinedFrames(sf *Stackframe) []*Stackframe {
+	calls := bi.inlinedCallsIncludePc(sf.lookupPc())
+	frames := make([]*Stackframe, 0, len(calls))
+	for i, call := range calls {
+		frame := &Stackframe{pc: sf.pc, cfa: sf.cfa, regs: sf.regs, ret: sf.ret, fn: call.origin, call: sf.call, inlined: true, outer: sf.outer}
+		if i > 0 {
+			frame.filename, frame.line = calls[i-1].callFile, calls[i-1].callLine
+		}
+		frames = append(frames, frame)
+	}
+	if len(calls) > 0 {
+		outermost := calls[len(calls)-1]
+		sf.filename, sf.line = outermost.callFile, outermost.callLine
+	}
+	return frames
+}
+
+// location returns the source line of the frame.
+func (bi *BI) location(sf *Stackframe) (string, int, error) {
+	if sf.filename != "" {
+		return sf.filename, sf.line, nil
+	}
+	return bi.pcTofileLine(sf.lookupPc())
+}
+
+// unwindFrame computes the cfa and the return address of sf, and returns the registers of the caller.
+// The callee-saved registers are restored by the rules of the fde, and the frame pointer is used only if no fde covers pc.
+func (bi *BI) unwindFrame(pid int, sf *Stackframe) ([]uint64, error) {
+	var (
+		frame *Frame
+		err   error
+	)
+	if sf.call && isSignalTrampoline(bi, sf.pc) {
+		return bi.unwindSignalFrame(pid, sf)
+	}
+	pc := sf.lookupPc()
+	sf.fn, _ = bi.findFunctionIncludePc(pc)
+
+	callerRegs := append([]uint64(nil), sf.regs...)
+	if frame, err = bi.computeFrame(pid, pc, sf.regs); err != nil {
+		if _, ok := err.(*NotFoundFrameErr); !ok {
+			return nil, err
+		}
+		return bi.unwindFrameByFramePointer(pid, sf)
+	}
+
+	sf.cfa = frame.framebase
+	callerRegs[dwarfRegRsp] = sf.cfa
+	// the return address is stored at cfa-8 by `CALL`, if it isn't described by the cie
+	callerRegs[dwarfRegPc] = 0
+	if _, ok := frame.regsRule[dwarfRegPc]; !ok {
+		if callerRegs[dwarfRegPc], err = readUint64(pid, sf.cfa-8); err != nil {
+			return nil, err
+		}
+	}
+	for reg, rule := range frame.regsRule {
+		if reg >= uint64(len(callerRegs)) {
+			continue
+		}
+		switch rule.rule {
+		case RuleUndefined:
+			callerRegs[reg] = 0
+		case RuleSameVal:
+		case RuleOffset:
+			if callerRegs[reg], err = readUint64(pid, uint64(int64(sf.cfa)+rule.offset)); err != nil {
+				return nil, err
+			}
+		case RuleValOffset:
+			callerRegs[reg] = uint64(int64(sf.cfa) + rule.offset)
+		case RuleRegister:
+			if rule.reg < uint64(len(sf.regs)) {
+				callerRegs[reg] = sf.regs[rule.reg]
+			}
+		case RuleExpression:
+			var addr uint64
+			if addr, err = execDwarfExpression(pid, rule.expression, sf.regs, sf.cfa, sf.cfa); err != nil {
+				return nil, err
+			}
+			if callerRegs[reg], err = readUint64(pid, addr); err != nil {
+				return nil, err
+			}
+		case RuleValExpression:
+			if callerRegs[reg], err = execDwarfExpression(pid, rule.expression, sf.regs, sf.cfa, sf.cfa); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sf.ret = callerRegs[dwarfRegPc]
+	return callerRegs, nil
+}
+
+// unwindFrameByFramePointer uses the saved rbp chain: [rbp] is the rbp of the caller, [rbp+8] is the return address.
+func (bi *BI) unwindFrameByFramePointer(pid int, sf *Stackframe) ([]uint64, error) {
+	var err error
+	rbp := sf.regs[dwarfRegRbp]
+	sf.fp = true
+	if rbp == 0 {
+		sf.ret = 0
+		return nil, nil
+	}
+
+	callerRegs := append([]uint64(nil), sf.regs...)
+	sf.cfa = rbp + 16
+	callerRegs[dwarfRegRsp] = sf.cfa
+	if callerRegs[dwarfRegRbp], err = readUint64(pid, rbp); err != nil {
+		return nil, err
+	}
+	if callerRegs[dwarfRegPc], err = readUint64(pid, rbp+8); err != nil {
+		return nil, err
+	}
+	sf.ret = callerRegs[dwarfRegPc]
+	return callerRegs, nil
+}
+
+// the offset of struct sigcontext in struct ucontext, and the registers in it, which are numbered by DWARF.
+// The kernel pushes the return address to the signal trampoline, then the ucontext of struct rt_sigframe.
+const ucontextMcontextOffset = 40
+
+var sigcontextRegs = []struct {
+	reg    int
+	offset uint64
+}{
+	{8, 0}, {9, 8}, {10, 16}, {11, 24}, {12, 32}, {13, 40}, {14, 48}, {15, 56},
+	{5, 64}, {4, 72}, {dwarfRegRbp, 80}, {3, 88}, {1, 96}, {0, 104}, {2, 112},
+	{dwarfRegRsp, 120}, {dwarfRegPc, 128},
+}
+
+// isSignalTrampoline reports whether pc is the entry of the function which calls rt_sigreturn,
+// it is the return address of the signal handler.
+func isSignalTrampoline(bi *BI, pc uint64) bool {
+	fn, err := bi.findFunctionIncludePc(pc)
+	if err != nil || fn.lowpc != pc {
+		return false
+	}
+	return fn.name == "runtime.sigreturn__sigaction" || fn.name == "runtime.sigreturn"
+}
+
+// unwindSignalFrame restores the registers of the interrupted frame from the ucontext of the signal frame.
+func (bi *BI) unwindSignalFrame(pid int, sf *Stackframe) ([]uint64, error) {
+	var err error
+	sf.call = false
+	sf.signal = true
+	sf.fn, _ = bi.findFunctionIncludePc(sf.pc)
+	sf.cfa = sf.regs[dwarfRegRsp]
+
+	callerRegs := append([]uint64(nil), sf.regs...)
+	mcontext := sf.cfa + ucontextMcontextOffset
+	for _, r := range sigcontextRegs {
+		if callerRegs[r.reg], err = readUint64(pid, mcontext+r.offset); err != nil {
+			return nil, err
+		}
+	}
+	sf.ret = callerRegs[dwarfRegPc]
+	return callerRegs, nil
+}
+
+// isOutermostFunction reports whether the function has no caller to unwind.
+func isOutermostFunction(name string) bool {
+	switch name {
+	case "runtime.goexit", "runtime.mstart", "runtime.rt0_go", "runtime.mcall", "runtime.morestack":
+		return true
+	}
+	return false
+}
+
+// selectedFrame returns the frame which is selected by `frame`, `up` and `down`, print and list work in it.
+func (bi *BI) selectedFrame(bp *BP, pid int) (*Stackframe, error) {
+	frames, err := bi.stacktrace(bp, pid, target.frame+1)
+	if err != nil {
+		return nil, err
+	}
+	if target.frame >= len(frames) {
+		target.frame = len(frames) - 1
+	}
+	return frames[target.frame], nil
+}
+
+// selectFrame selects the frame index and prints its location.
+func (bi *BI) selectFrame(bp *BP, pid int, index int) error {
+	var (
+		frames   []*Stackframe
+		err      error
+		filename string
+		line     int
+	)
+	if index < 0 {
+		return InitialFrameErr
+	}
+	if frames, err = bi.stacktrace(bp, pid, index+1); err != nil {
+		return err
+	}
+	if index >= len(frames) {
+		return OutermostFrameErr
+	}
+	target.frame = index
+
+	sf := frames[index]
+	if filename, line, err = bi.location(sf); err != nil {
+		return err
+	}
+	name := "?"
+	if sf.fn != nil {
+		name = sf.fn.name
+	}
+	fmt.Fprintf(stdout, "#%-2d 0x%x in %s at %s:%d\n", index, sf.pc, name, filename, line)
+	return listFileLine(filename, line, 6)
+}
+
+// isRuntimeFrame reports whether the frame is in the go runtime, which is marked in bt.
+func (sf *Stackframe) isRuntimeFrame() bool {
+	return sf.fn != nil && strings.HasPrefix(sf.fn.name, "runtime.")
+}
+
+// printStacktrace prints at most depth frames, with the values of arguments if args, and the locals if full.
+func (bi *BI) printStacktrace(bp *BP, pid int, depth int, args bool, full bool) error {
+	var (
+		frames   []*Stackframe
+		err      error
+		filename string
+		line     int
+	)
+	if frames, err = bi.stacktrace(bp, pid, depth); err != nil {
+		return err
+	}
+	for i, sf := range frames {
+		if filename, line, err = bi.location(sf); err != nil {
+			return err
+		}
+		name := "?"
+		if sf.fn != nil {
+			name = sf.fn.name
+		}
+		if args && sf.fn != nil {
+			name += "(" + strings.Join(bi.frameVariables(pid, sf, dwarf.TagFormalParameter), ", ") + ")"
+		}
+		mark := ""
+		if sf.inlined {
+			mark += " [inlined]"
+		}
+		if sf.isRuntimeFrame() {
+			mark += " [runtime]"
+		}
+		selected := " "
+		if i == target.frame {
+			selected = "*"
+		}
+		fmt.Fprintf(stdout, "%s#%-2d pc=0x%-8x sp=0x%-12x cfa=0x%-12x %s:%d %s%s\n",
+			selected, i, sf.pc, sf.regs[dwarfRegRsp], sf.cfa, filename, line, name, mark)
+		if full {
+			for _, v := range bi.frameVariables(pid, sf, dwarf.TagVariable) {
+				fmt.Fprintf(stdout, "        %s\n", v)
+			}
+		}
+	}
+	return nil
+}
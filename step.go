@@ -0,0 +1,507 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/arch/x86/x86asm"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// StopReason tells why the tracee gave control back to the debugger.
+type StopReason uint8
+
+const (
+	StopDone       StopReason = iota // the command finished normally
+	StopBreakPoint                   // run into a user breakpoint
+	StopSignal                       // stopped by a signal which is not caused by the debugger
+	StopExited                       // the process has exited
+	StopKilled                       // the process is killed by a signal
+)
+
+func stopReasonOf(s syscall.WaitStatus) (StopReason, error) {
+	if s.Exited() {
+		return StopExited, nil
+	}
+	if s.Signaled() {
+		return StopKilled, fmt.Errorf("the process is killed by signal %s", signalName(s.Signal()))
+	}
+	if s.StopSignal() != syscall.SIGTRAP {
+		// the signal stops the process by its policy of `handle`, it has been printed
+		return StopSignal, nil
+	}
+	return StopDone, nil
+}
+
+// singleStepInstruction executes exactly one instruction of the tracee.
+// If the tracee has just trapped on a breakpoint (pc-1) or is standing right on one (pc),
+// the pc is rewound and the original instruction is executed instead of int3.
+func (bp *BP) singleStepInstruction(pid int) (syscall.WaitStatus, error) {
+	var (
+		s    syscall.WaitStatus
+		pc   uint64
+		err  error
+		info *BInfo
+		ok   bool
+	)
+
+	if pc, err = getPtracePc(); err != nil {
+		return s, err
+	}
+	if info, ok = bp.findBreakPoint(pc - 1); ok {
+		if err = setPcRegister(target.cmd, pc-1); err != nil {
+			return s, err
+		}
+	} else {
+		info, ok = bp.findBreakPoint(pc)
+	}
+	if ok {
+		if err = bp.disableBreakPoint(pid, info); err != nil {
+			return s, err
+		}
+		defer bp.enableBreakPoint(pid, info)
+	}
+
+	if target.record.isRecording() {
+		return target.record.recordSingleStep(bp, pid)
+	}
+	if err = currentProcess().SingleStep(pid); err != nil {
+		return s, err
+	}
+	return currentProcess().Wait(pid)
+}
+
+// stepInstruction executes one instruction and reports whether the tracee
+// stands on a user breakpoint afterwards.
+func (bp *BP) stepInstruction(pid int) (StopReason, error) {
+	var (
+		s      syscall.WaitStatus
+		reason StopReason
+		pc     uint64
+		err    error
+		info   *BInfo
+		ok     bool
+	)
+	if s, err = bp.singleStepInstruction(pid); err != nil {
+		return StopDone, err
+	}
+	if reason, err = stopReasonOf(s); reason != StopDone {
+		return reason, err
+	}
+	if pc, err = getPtracePc(); err != nil {
+		return StopDone, err
+	}
+	if info, ok = bp.findBreakPoint(pc); ok && info.kind == USERBPTYPE {
+		return StopBreakPoint, nil
+	}
+	return StopDone, nil
+}
+
+// nextInstruction is like stepInstruction, but a `CALL` is stepped over at instruction granularity:
+// the callee is single-stepped until it returns to the instruction following the call.
+// It stops early if the callee runs into a user breakpoint.
+func (bp *BP) nextInstruction(bi *BI, pid int) (StopReason, error) {
+	var (
+		reason StopReason
+		regs   syscall.PtraceRegs
+		err    error
+		inst   x86asm.Inst
+		ok     bool
+	)
+
+	if regs, err = getRegisters(target.cmd); err != nil {
+		return StopDone, err
+	}
+	if _, ok = bp.findBreakPoint(regs.PC() - 1); ok {
+		regs.SetPC(regs.PC() - 1)
+	}
+	if inst, err = bp.getSingleMemInst(pid, regs.PC()); err != nil {
+		return StopDone, err
+	}
+	if inst.Op != x86asm.CALL && inst.Op != x86asm.LCALL {
+		return bp.stepInstruction(pid)
+	}
+
+	call := newCallSite(bi, pid, &regs, inst)
+	for {
+		if reason, err = bp.stepInstruction(pid); reason != StopDone && reason != StopBreakPoint || err != nil {
+			return reason, err
+		}
+		if regs, err = getRegisters(target.cmd); err != nil {
+			return StopDone, err
+		}
+		if call.returned(pid, &regs) {
+			return reason, nil
+		}
+		if reason == StopBreakPoint {
+			return reason, nil
+		}
+	}
+}
+
+// callSite is a `CALL` instruction which is being stepped over.
+type callSite struct {
+	retpc     uint64
+	sp        uint64
+	stackhi   uint64 // the top of the goroutine stack, 0 if unknown
+	morestack bool
+}
+
+func newCallSite(bi *BI, pid int, regs *syscall.PtraceRegs, inst x86asm.Inst) *callSite {
+	call := &callSite{retpc: regs.PC() + uint64(inst.Len), sp: regs.Rsp}
+	call.stackhi, _ = goroutineStackHi(pid, regs)
+	if rel, ok := inst.Args[0].(x86asm.Rel); ok {
+		if f, err := bi.findFunctionIncludePc(call.retpc + uint64(int64(rel))); err == nil {
+			call.morestack = strings.HasPrefix(f.name, "runtime.morestack")
+		}
+	}
+	return call
+}
+
+// returned reports whether the tracee has returned from the call.
+// Go copies the whole stack when it grows, so the frame is recognized by its distance to the top
+// of the goroutine stack instead of rsp. The split-stack prologue calls runtime.morestack, which resumes
+// at the return address on the new stack and jumps back to the function entry.
+func (call *callSite) returned(pid int, regs *syscall.PtraceRegs) bool {
+	if regs.PC() != call.retpc {
+		return false
+	}
+	if call.morestack {
+		return true
+	}
+	if call.stackhi != 0 {
+		if stackhi, err := goroutineStackHi(pid, regs); err == nil && stackhi != 0 {
+			return stackhi-regs.Rsp <= call.stackhi-call.sp
+		}
+	}
+	return regs.Rsp >= call.sp
+}
+
+// goroutineStackHi reads `g.stack.hi` of the running goroutine, g is kept in the TLS slot at fs_base-8.
+func goroutineStackHi(pid int, regs *syscall.PtraceRegs) (uint64, error) {
+	g, err := readUint64(pid, regs.Fs_base-8)
+	if err != nil || g == 0 {
+		return 0, err
+	}
+	// type stack struct { lo, hi uintptr }, which is the first field of g
+	return readUint64(pid, g+8)
+}
+
+// stepLine single-steps the tracee until it reaches another source line, function calls are entered.
+// Functions in the skip list are run to completion, and the code without caller is stepped through.
+func (bp *BP) stepLine(bi *BI, pid int) (StopReason, error) {
+	var (
+		reason      StopReason
+		err         error
+		pc          uint64
+		regs        syscall.PtraceRegs
+		inst        x86asm.Inst
+		filename    string
+		lineno      int
+		oldfilename string
+		oldlineno   int
+		ok          bool
+		startf      *Function
+	)
+
+	if pc, err = getPtracePc(); err != nil {
+		return StopDone, err
+	}
+	if _, ok = bp.findBreakPoint(pc - 1); ok {
+		pc = pc - 1
+	}
+	if oldfilename, oldlineno, err = bi.pcTofileLine(pc); err != nil {
+		return StopDone, err
+	}
+	startf, _ = bi.findFunctionIncludePc(pc)
+	for {
+		if regs, err = getRegisters(target.cmd); err != nil {
+			return StopDone, err
+		}
+		if _, ok = bp.findBreakPoint(regs.PC() - 1); ok {
+			regs.SetPC(regs.PC() - 1)
+		}
+		if inst, err = bp.getSingleMemInst(pid, regs.PC()); err != nil {
+			return StopDone, err
+		}
+		if reason, err = bp.stepInstruction(pid); reason != StopDone || err != nil {
+			return reason, err
+		}
+		if pc, err = getPtracePc(); err != nil {
+			return StopDone, err
+		}
+
+		iscall := inst.Op == x86asm.CALL || inst.Op == x86asm.LCALL
+		f, _ := bi.findFunctionIncludePc(pc)
+		if (f == nil || f != startf) && target.skip.shouldSkip(bi, pc) {
+			// returned or jumped into the skipped code, step through it
+			if !iscall {
+				continue
+			}
+			call := newCallSite(bi, pid, &regs, inst)
+			if reason, err = bp.finishFunction(pid, call); reason != StopDone || err != nil {
+				return reason, err
+			}
+			// the call may return on another thread, the steps go on in it
+			pid = currentThread()
+			pc = call.retpc
+		} else if iscall && f != nil && pc == f.lowpc {
+			// entered a function, don't stop in its prologue
+			return bp.runToPrologueEnd(bi, pid, f)
+		}
+
+		if filename, lineno, err = bi.pcTofileLine(pc); err != nil {
+			return StopDone, err
+		}
+		if !(filename == oldfilename && lineno == oldlineno) {
+			return StopDone, nil
+		}
+	}
+}
+
+// runToPrologueEnd steps the tracee from the entry of f to the end of its prologue,
+// which checks the stack bound and calls runtime.morestack if the stack needs to grow.
+func (bp *BP) runToPrologueEnd(bi *BI, pid int, f *Function) (StopReason, error) {
+	var (
+		reason StopReason
+		pc     uint64
+		err    error
+		end    uint64
+		ok     bool
+	)
+	if end, ok = bi.prologueEnd(f); !ok {
+		return StopDone, nil
+	}
+	for {
+		if pc, err = getPtracePc(); err != nil {
+			return StopDone, err
+		}
+		if pc == end || !f.contains(pc) {
+			return StopDone, nil
+		}
+		if reason, err = bp.nextInstruction(bi, pid); reason != StopDone || err != nil {
+			return reason, err
+		}
+	}
+}
+
+// finishFunction runs the tracee until the call returns.
+// It uses an internal breakpoint at the return address, recursive calls are recognized by the stack.
+func (bp *BP) finishFunction(pid int, call *callSite) (StopReason, error) {
+	var (
+		info   *BInfo
+		reason StopReason
+		regs   syscall.PtraceRegs
+		err    error
+		ok     bool
+	)
+	if _, ok = bp.findBreakPoint(call.retpc); !ok {
+		if info, err = bp.SetInternalBreakPoint(pid, call.retpc); err != nil {
+			return StopDone, err
+		}
+		defer func() {
+			_ = bp.disableBreakPoint(pid, info)
+			bp.clearInternalBreakPoint(call.retpc)
+		}()
+	}
+
+	for {
+		if reason, err = bp.continueProcess(pid); reason != StopBreakPoint || err != nil {
+			return reason, err
+		}
+		if regs, err = getRegisters(target.cmd); err != nil {
+			return StopDone, err
+		}
+		tid := currentThread()
+		// trapped on int3, or stopped right on the breakpoint when recording
+		hit, trapped := bp.findBreakPoint(regs.PC() - 1)
+		if trapped {
+			regs.SetPC(regs.PC() - 1)
+		}
+		// the goroutine may return on another thread, which stays the current thread
+		if (trapped || tid == pid) && call.returned(tid, &regs) {
+			if info != nil {
+				return StopDone, setPcRegister(target.cmd, call.retpc)
+			}
+			return StopBreakPoint, nil
+		}
+		if tid != pid {
+			// another thread traps, it stops on the user breakpoints,
+			// and steps over the internal ones while the other threads are stopped
+			if !trapped || hit.kind == USERBPTYPE {
+				return StopBreakPoint, nil
+			}
+			if reason, err = bp.singleStepInstructionWithBreakpointCheck(tid); reason != StopDone || err != nil {
+				return reason, err
+			}
+			target.tid = pid
+			continue
+		}
+		if hit, ok := bp.findBreakPoint(regs.PC()); ok && hit.kind == USERBPTYPE {
+			return StopBreakPoint, nil
+		}
+	}
+}
+
+// nextLine single-steps the tracee until it reaches another source line, function calls are stepped over.
+func (bp *BP) nextLine(bi *BI, pid int) (StopReason, error) {
+	var (
+		reason      StopReason
+		err         error
+		pc          uint64
+		filename    string
+		lineno      int
+		oldfilename string
+		oldlineno   int
+		ok          bool
+	)
+
+	if pc, err = getPtracePc(); err != nil {
+		return StopDone, err
+	}
+	if _, ok = bp.findBreakPoint(pc - 1); ok {
+		pc = pc - 1
+	}
+	if oldfilename, oldlineno, err = bi.pcTofileLine(pc); err != nil {
+		return StopDone, err
+	}
+	// the lines of inlined calls are stepped over like the calls
+	depth := len(bi.inlinedCallsIncludePc(pc))
+	for {
+		// the current thread changes if a call returns on another thread
+		if reason, err = bp.nextInstruction(bi, currentThread()); reason != StopDone || err != nil {
+			return reason, err
+		}
+		if pc, err = getPtracePc(); err != nil {
+			return StopDone, err
+		}
+		if filename, lineno, err = bi.pcTofileLine(pc); err != nil {
+			return StopDone, err
+		}
+		if !(filename == oldfilename && lineno == oldlineno) && len(bi.inlinedCallsIncludePc(pc)) <= depth {
+			return StopDone, nil
+		}
+	}
+}
+
+// continueProcess resumes the tracee until it traps on a breakpoint, is stopped by a signal or exits.
+func (bp *BP) continueProcess(pid int) (StopReason, error) {
+	var (
+		s      syscall.WaitStatus
+		reason StopReason
+		pc     uint64
+		err    error
+	)
+	// every instruction has to be recorded, so the tracee is single-stepped to the breakpoint
+	if target.record.isRecording() {
+		for {
+			if reason, err = bp.stepInstruction(pid); reason != StopDone || err != nil {
+				return reason, err
+			}
+			// the internal breakpoints stop the tracee too, like the trap of int3
+			if pc, err = getPtracePc(); err != nil {
+				return StopDone, err
+			}
+			if _, ok := bp.findBreakPoint(pc); ok {
+				return StopBreakPoint, nil
+			}
+		}
+	}
+
+	if reason, err = bp.singleStepInstructionWithBreakpointCheck(pid); reason != StopDone || err != nil {
+		return reason, err
+	}
+	if err = bp.Continue(pid); err != nil {
+		return StopDone, err
+	}
+	if s, err = currentProcess().Wait(-1); err != nil {
+		return StopDone, err
+	}
+	if reason, err = stopReasonOf(s); reason != StopDone {
+		return reason, err
+	}
+	return StopBreakPoint, nil
+}
+
+const (
+	skipStdlib = "<stdlib>"
+	skipNoLine = "<noline>"
+)
+
+// SkipList holds the functions which `step` doesn't stop in.
+// A pattern is a function name, a prefix of names ending with `*`,
+// `<stdlib>` for the standard library or `<noline>` for the code without line information.
+type SkipList struct {
+	patterns []string
+}
+
+func newSkipList() *SkipList {
+	return &SkipList{patterns: []string{"runtime.*", skipStdlib, skipNoLine}}
+}
+
+func (sl *SkipList) Add(pattern string) error {
+	for _, v := range sl.patterns {
+		if v == pattern {
+			return fmt.Errorf("skip pattern `%s` has existed", pattern)
+		}
+	}
+	sl.patterns = append(sl.patterns, pattern)
+	return nil
+}
+
+func (sl *SkipList) Delete(pattern string) error {
+	for i, v := range sl.patterns {
+		if v == pattern {
+			sl.patterns = append(sl.patterns[:i], sl.patterns[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("can't find skip pattern `%s`", pattern)
+}
+
+func (sl *SkipList) shouldSkip(bi *BI, pc uint64) bool {
+	if sl == nil || len(sl.patterns) == 0 {
+		return false
+	}
+	f, err := bi.findFunctionIncludePc(pc)
+	filename, _, _ := bi.pcTofileLine(pc)
+	for _, pattern := range sl.patterns {
+		switch {
+		case pattern == skipNoLine:
+			if err != nil || !hasLineInfo(filename) {
+				return true
+			}
+		case f == nil:
+			continue
+		case pattern == skipStdlib:
+			if isStdlibFile(filename) {
+				return true
+			}
+		case strings.HasSuffix(pattern, "*"):
+			if strings.HasPrefix(f.name, pattern[:len(pattern)-1]) {
+				return true
+			}
+		case f.name == pattern:
+			return true
+		}
+	}
+	return false
+}
+
+func hasLineInfo(filename string) bool {
+	return filename != "" && filename != "<autogenerated>"
+}
+
+func isStdlibFile(filename string) bool {
+	if strings.HasPrefix(filename, "$GOROOT/") {
+		return true
+	}
+	for _, goroot := range []string{os.Getenv("GOROOT"), runtime.GOROOT()} {
+		if goroot != "" && strings.HasPrefix(filename, path.Join(goroot, "src")+"/") {
+			return true
+		}
+	}
+	return false
+}
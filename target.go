@@ -9,4 +9,36 @@ type Target struct {
 	bi       *BI
 	cmd      *exec.Cmd
 	execFile string
+	record   *Recorder
+	skip     *SkipList
+	signals  *SignalTable
+	frame    int // the index of the selected frame in the stacktrace, 0 is the innermost
+
+	// the arguments, environment, working directory and redirections of the program, which are kept by `restart`
+	programOptions
+
+	// all the threads of the process are traced, and they are stopped together when one of them stops
+	threads  []int
+	tid      int  // the current thread, which stopped last
+	attached bool // the process is attached by `godbg attach`, it keeps running after the debugger quits
+	// the new threads which haven't reported their first SIGSTOP, they may be created while single-stepping
+	starting map[int]bool
+
+	// the core file of `godbg core`, which is read instead of the process
+	core *Core
+	// the backend of the process, nil is the process which is traced by ptrace
+	process Process
+	// the memory of the stopped process which has been read
+	pages pageCache
+}
+
+// currentThread returns the thread which is inspected and stepped, it is the process itself before any thread stops.
+func currentThread() int {
+	if target.tid != 0 {
+		return target.tid
+	}
+	if target.cmd == nil || target.cmd.Process == nil {
+		return 0
+	}
+	return target.cmd.Process.Pid
 }
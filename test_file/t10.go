@@ -0,0 +1,20 @@
+package main
+
+import "fmt"
+
+func double(n int) int {
+	return n * 2
+}
+
+//go:noinline
+func sum(n int) int {
+	total := 0
+	for i := 0; i < n; i++ {
+		total += double(i)
+	}
+	return total
+}
+
+func main() {
+	fmt.Println(sum(10))
+}
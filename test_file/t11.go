@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+func work(i int) int {
+	return i * 2
+}
+
+func main() {
+	// the goroutines keep the other threads busy
+	for i := 0; i < 4; i++ {
+		go func() {
+			for {
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	for i := 0; ; i++ {
+		if work(i) < 0 {
+			fmt.Fprintln(os.Stderr, "overflow")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,5 @@
+package main
+
+func double(n int) int {
+	return n * 2
+}
@@ -0,0 +1,8 @@
+package main
+
+import "fmt"
+
+func main() {
+	n := double(21)
+	fmt.Println(n, tag())
+}
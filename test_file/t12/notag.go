@@ -0,0 +1,7 @@
+//go:build !godbg
+
+package main
+
+func tag() string {
+	return "notag"
+}
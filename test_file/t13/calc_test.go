@@ -0,0 +1,22 @@
+package calc
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	if Add(1, 2) != 3 {
+		t.Fatal("1 + 2 != 3")
+	}
+}
+
+func TestData(t *testing.T) {
+	// the test runs in the directory of the package
+	if _, err := ioutil.ReadFile("calc.go"); err != nil {
+		t.Fatal(err)
+	}
+	if Add(3, 4) != 7 {
+		t.Fatal("3 + 4 != 7")
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	args := strings.Join(os.Args[1:], " ")
+	env := os.Getenv("GODBG_ENV")
+	wd, _ := os.Getwd()
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Println(args, env, wd, strings.TrimSpace(line))
+	fmt.Fprintln(os.Stderr, "to stderr")
+}
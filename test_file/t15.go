@@ -0,0 +1,19 @@
+package main
+
+import "fmt"
+
+type account struct {
+	name    string
+	balance *int
+}
+
+func withdraw(a *account, n int) int {
+	left := *a.balance - n
+	return left
+}
+
+func main() {
+	s := "hello core"
+	a := &account{name: s}
+	fmt.Println(withdraw(a, len(s)))
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	result := "timeout"
+	select {
+	case sig := <-c:
+		result = sig.String()
+	case <-time.After(3 * time.Second):
+	}
+	fmt.Println(result)
+}
@@ -0,0 +1,12 @@
+package main
+
+import "fmt"
+
+//go:noinline
+func scale(f float64) float64 {
+	return f * 2
+}
+
+func main() {
+	fmt.Println(scale(1.5))
+}
@@ -0,0 +1,19 @@
+package main
+
+import "fmt"
+
+func grow(n int) int {
+	var buf [1024]byte
+	buf[n%1024] = byte(n)
+	if n == 0 {
+		return int(buf[0])
+	}
+	return grow(n-1) + int(buf[n%1024])
+}
+
+func main() {
+	s := grow(50)
+	fmt.Println(s)
+	s = grow(60)
+	fmt.Println(s)
+}
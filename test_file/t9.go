@@ -0,0 +1,18 @@
+package main
+
+import "fmt"
+
+func add(a, b int) int {
+	return a + b
+}
+
+func twice(a int) int {
+	return add(a, a)
+}
+
+func main() {
+	s := twice(3)
+	fmt.Println(s)
+	fmt.Println(add(s, 1))
+	fmt.Println(add(s, 2))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"syscall"
+)
+
+// The goroutines run on any thread of the process, so all the threads are traced by PTRACE_O_TRACECLONE,
+// a breakpoint which is hit on an untraced thread would kill the process by SIGTRAP.
+// The threads are stopped together when one of them stops, and they are resumed together.
+
+func isThreadTraced(tid int) bool {
+	for _, v := range target.threads {
+		if v == tid {
+			return true
+		}
+	}
+	return false
+}
+
+func removeThread(tid int) {
+	for i, v := range target.threads {
+		if v == tid {
+			target.threads = append(target.threads[:i], target.threads[i+1:]...)
+			return
+		}
+	}
+}
+
+// addClonedThread traces the thread which is created by the stopped thread tid,
+// the new thread starts with SIGSTOP, which is waited by waitThreads or stopOtherThreads.
+func addClonedThread(tid int) {
+	msg, err := syscall.PtraceGetEventMsg(tid)
+	if err != nil {
+		logger.Error("addClonedThread", zap.Error(err), zap.Int("tid", tid))
+		return
+	}
+	if newTid := int(msg); !isThreadTraced(newTid) {
+		target.threads = append(target.threads, newTid)
+		target.starting[newTid] = true
+	}
+}
+
+// resumeOtherThreads continues the threads except the current thread tid, before tid is continued.
+func resumeOtherThreads(tid int) error {
+	for _, other := range append([]int(nil), target.threads...) {
+		if other == tid {
+			continue
+		}
+		if err := syscall.PtraceCont(other, target.signals.takePending(other)); err != nil {
+			if err == syscall.ESRCH {
+				removeThread(other)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// waitThreads waits until a thread of the process traps or the process exits,
+// the trapped thread becomes the current thread and all the other threads are stopped.
+// The other signals are handled by their policies of `handle`, they stop the process like a trap,
+// or they are passed to the threads which receive them at once.
+func waitThreads(bp *BP, s *syscall.WaitStatus) error {
+	var (
+		wpid int
+		err  error
+	)
+	pid := target.cmd.Process.Pid
+	for {
+		if wpid, err = syscall.Wait4(-1, s, syscall.WALL, nil); err != nil {
+			return err
+		}
+		if s.Exited() || s.Signaled() {
+			removeThread(wpid)
+			// the leader is reported after all the other threads
+			if wpid == pid {
+				return nil
+			}
+			continue
+		}
+		if !s.Stopped() {
+			continue
+		}
+		sig := s.StopSignal()
+		// a new thread which starts with SIGSTOP, may be reported before the clone event
+		starting := !isThreadTraced(wpid) || target.starting[wpid]
+		// the policy is applied once to the signals of the program, which aren't the traps and the new threads
+		stop := sig == syscall.SIGTRAP
+		if !starting && !stop {
+			stop = target.signals.received(wpid, sig)
+		}
+		switch {
+		case starting:
+			if !isThreadTraced(wpid) {
+				target.threads = append(target.threads, wpid)
+			}
+			delete(target.starting, wpid)
+			err = syscall.PtraceCont(wpid, 0)
+		case sig == syscall.SIGTRAP && s.TrapCause() == syscall.PTRACE_EVENT_CLONE:
+			addClonedThread(wpid)
+			err = syscall.PtraceCont(wpid, 0)
+		case stop:
+			target.tid = wpid
+			return stopOtherThreads(bp, wpid)
+		default:
+			err = syscall.PtraceCont(wpid, target.signals.takePending(wpid))
+		}
+		if err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+}
+
+// stopOtherThreads stops the threads except tid by SIGSTOP, if a thread traps on a breakpoint at the same time,
+// its pc is rewound, so it traps again after resuming.
+func stopOtherThreads(bp *BP, tid int) error {
+	var (
+		s    syscall.WaitStatus
+		regs syscall.PtraceRegs
+		err  error
+	)
+	pid := target.cmd.Process.Pid
+	for _, other := range append([]int(nil), target.threads...) {
+		// the new threads are stopped by their first SIGSTOP, another one would be reported after resuming
+		if other == tid || target.starting[other] {
+			continue
+		}
+		if err = syscall.Tgkill(pid, other, syscall.SIGSTOP); err != nil {
+			if err == syscall.ESRCH {
+				removeThread(other)
+				continue
+			}
+			return err
+		}
+		for {
+			if _, err = syscall.Wait4(other, &s, syscall.WALL, nil); err != nil {
+				return err
+			}
+			if s.Exited() || s.Signaled() {
+				removeThread(other)
+				break
+			}
+			sig := s.StopSignal()
+			if sig == syscall.SIGSTOP {
+				delete(target.starting, other)
+				break
+			}
+			if sig == syscall.SIGTRAP && s.TrapCause() == syscall.PTRACE_EVENT_CLONE {
+				addClonedThread(other)
+			} else if sig == syscall.SIGTRAP {
+				if regs, err = currentProcess().Registers(other); err != nil {
+					return err
+				}
+				if _, ok := bp.findBreakPoint(regs.PC() - 1); ok {
+					regs.SetPC(regs.PC() - 1)
+					if err = currentProcess().SetRegisters(other, &regs); err != nil {
+						return err
+					}
+				}
+			} else {
+				// the process is stopping already, the signal is delivered when the thread is continued
+				target.signals.received(other, sig)
+			}
+			// the pending SIGSTOP is delivered at once
+			if err = syscall.PtraceCont(other, 0); err != nil {
+				return err
+			}
+		}
+	}
+	// the new threads which haven't reported their SIGSTOP are stopped by it
+	for other := range target.starting {
+		if _, err = syscall.Wait4(other, &s, syscall.WALL, nil); err != nil {
+			return err
+		}
+		if s.Exited() || s.Signaled() {
+			removeThread(other)
+		}
+		delete(target.starting, other)
+	}
+	return nil
+}
+
+// killProcess kills the process pid and all of its threads, and waits until they exit,
+// so the stops of them aren't reported to the next process.
+func killProcess(pid int) {
+	var s syscall.WaitStatus
+	if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil {
+		return
+	}
+	// the process is the leader of its process group, which has no other processes
+	for {
+		if _, err := syscall.Wait4(-pid, &s, syscall.WALL, nil); err != nil {
+			return
+		}
+	}
+}
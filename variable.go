@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// maxStringLen limits the bytes which are read for a string, the length may be garbage before it is initialized.
+const maxStringLen = 64 * 1024
+
+func variableName(fv *dwarf.Entry) string {
+	if field := fv.AttrField(dwarf.AttrName); field != nil {
+		if name, ok := field.Val.(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// variableType returns the type of the variable, or nil if it is unknown.
+func (bi *BI) variableType(fv *dwarf.Entry) dwarf.Type {
+	field := fv.AttrField(dwarf.AttrType)
+	if field == nil || bi.DwarfData == nil {
+		return nil
+	}
+	offset, ok := field.Val.(dwarf.Offset)
+	if !ok {
+		return nil
+	}
+	typ, err := bi.DwarfData.Type(offset)
+	if err != nil {
+		return nil
+	}
+	return typ
+}
+
+// pieceKind tells where a piece of the variable lives.
+type pieceKind int
+
+const (
+	pieceMemory pieceKind = iota
+	pieceRegister
+	pieceValue
+	pieceOptimizedOut
+)
+
+// locationPiece is a part of the variable, size 0 means the whole variable.
+type locationPiece struct {
+	kind  pieceKind
+	size  uint64
+	addr  uint64 // the address of the piece in memory
+	reg   uint64 // the DWARF number of the register which holds the piece
+	value []byte // the value of the piece itself, by DW_OP_stack_value or DW_OP_implicit_value
+}
+
+// locationPieces splits the location expression by DW_OP_piece, and evaluates every piece in the frame sf.
+func locationPieces(pid int, expr []byte, sf *Stackframe) ([]locationPiece, error) {
+	var (
+		opcode   byte
+		operands []byte
+		err      error
+		pieces   []locationPiece
+	)
+	if len(expr) == 0 {
+		return []locationPiece{{kind: pieceOptimizedOut}}, nil
+	}
+	buf := bytes.NewBuffer(expr)
+	start := 0
+	for buf.Len() > 0 {
+		pos := len(expr) - buf.Len()
+		if opcode, operands, err = nextDwarfOp(buf); err != nil {
+			return nil, err
+		}
+		if opcode != DW_OP_piece {
+			continue
+		}
+		piece, err := evalPiece(pid, expr[start:pos], sf)
+		if err != nil {
+			return nil, err
+		}
+		piece.size, _, _ = DecodeULEB128(bytes.NewBuffer(operands))
+		pieces = append(pieces, piece)
+		start = len(expr) - buf.Len()
+	}
+	if start < len(expr) {
+		piece, err := evalPiece(pid, expr[start:], sf)
+		if err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, piece)
+	}
+	return pieces, nil
+}
+
+// evalPiece evaluates the location expression of one piece, an empty expression means the piece is optimized out.
+func evalPiece(pid int, expr []byte, sf *Stackframe) (locationPiece, error) {
+	var (
+		opcode   byte
+		operands []byte
+		err      error
+	)
+	if len(expr) == 0 {
+		return locationPiece{kind: pieceOptimizedOut}, nil
+	}
+	buf := bytes.NewBuffer(expr)
+	if opcode, operands, err = nextDwarfOp(buf); err != nil {
+		return locationPiece{}, err
+	}
+	if buf.Len() == 0 {
+		switch {
+		case opcode >= DW_OP_reg0 && opcode <= DW_OP_reg31:
+			return locationPiece{kind: pieceRegister, reg: uint64(opcode - DW_OP_reg0)}, nil
+		case opcode == DW_OP_regx:
+			reg, _, _ := DecodeULEB128(bytes.NewBuffer(operands))
+			return locationPiece{kind: pieceRegister, reg: reg}, nil
+		case opcode == DW_OP_implicit_value:
+			ops := bytes.NewBuffer(operands)
+			length, _, _ := DecodeULEB128(ops)
+			return locationPiece{kind: pieceValue, value: ops.Next(int(length))}, nil
+		}
+	}
+
+	v, err := execDwarfExpression(pid, expr, sf.regs, sf.cfa)
+	if err != nil {
+		return locationPiece{}, err
+	}
+	if expr[len(expr)-1] == DW_OP_stack_value && isStackValue(expr) {
+		value := make([]byte, 8)
+		binary.LittleEndian.PutUint64(value, v)
+		return locationPiece{kind: pieceValue, value: value}, nil
+	}
+	return locationPiece{kind: pieceMemory, addr: v}, nil
+}
+
+// isStackValue reports whether the last operation of the expression is DW_OP_stack_value.
+func isStackValue(expr []byte) bool {
+	var opcode byte
+	buf := bytes.NewBuffer(expr)
+	for buf.Len() > 0 {
+		var err error
+		if opcode, _, err = nextDwarfOp(buf); err != nil {
+			return false
+		}
+	}
+	return opcode == DW_OP_stack_value
+}
+
+// readPieces reads size bytes of the variable from its pieces.
+func readPieces(pid int, pieces []locationPiece, sf *Stackframe, size uint64) ([]byte, error) {
+	if len(pieces) == 1 && pieces[0].size == 0 {
+		pieces[0].size = size
+	}
+	val := make([]byte, 0, size)
+	for _, piece := range pieces {
+		switch piece.kind {
+		case pieceMemory:
+			buf := make([]byte, piece.size)
+			if _, err := readMemory(pid, piece.addr, buf); err != nil {
+				return nil, err
+			}
+			val = append(val, buf...)
+		case pieceRegister:
+			buf, err := readPieceRegister(pid, piece, sf)
+			if err != nil {
+				return nil, err
+			}
+			val = append(val, buf...)
+		case pieceValue:
+			buf := make([]byte, piece.size)
+			copy(buf, piece.value)
+			val = append(val, buf...)
+		case pieceOptimizedOut:
+			return nil, OptimizedOutErr
+		}
+	}
+	if uint64(len(val)) < size {
+		return nil, OptimizedOutErr
+	}
+	return val[:size], nil
+}
+
+// readPieceRegister reads the register of piece in the frame sf, the xmm registers are read from the fp registers.
+func readPieceRegister(pid int, piece locationPiece, sf *Stackframe) ([]byte, error) {
+	if sf.outer && piece.reg != dwarfRegRsp && piece.reg != dwarfRegRbp && piece.reg != dwarfRegPc {
+		return nil, OptimizedOutErr
+	}
+	if piece.reg >= dwarfRegXmm0 && piece.reg <= dwarfRegXmm15 && piece.size <= 16 {
+		fpregs, err := currentProcess().FPRegisters(pid)
+		if err != nil {
+			return nil, err
+		}
+		offset := fpregsXmmOffset + 16*(piece.reg-dwarfRegXmm0)
+		return fpregs[offset : offset+piece.size], nil
+	}
+	if piece.reg >= uint64(len(sf.regs)) || piece.size > 8 {
+		return nil, fmt.Errorf("not support the register %d of piece", piece.reg)
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, sf.regs[piece.reg])
+	return buf[:piece.size], nil
+}
+
+// valueSize returns the bytes which are read for typ, just support base types, pointers and string for now.
+func valueSize(typ dwarf.Type) (uint64, error) {
+	if t, ok := typ.(*dwarf.TypedefType); ok {
+		return valueSize(t.Type)
+	}
+	size := typ.Size()
+	if t, ok := typ.(*dwarf.StructType); ok && t.StructName == "string" {
+		size = 16
+	}
+	if size <= 0 || size > 16 {
+		return 0, fmt.Errorf("not support type %s", typ.String())
+	}
+	return uint64(size), nil
+}
+
+// readVariable reads the value of the variable in the frame sf.
+func (bi *BI) readVariable(pid int, fv *dwarf.Entry, sf *Stackframe) (string, error) {
+	var (
+		expr   []byte
+		pieces []locationPiece
+		size   uint64
+		val    []byte
+		err    error
+	)
+	typ := bi.variableType(fv)
+	if typ == nil {
+		return "", fmt.Errorf("can't find the type of variable %s", variableName(fv))
+	}
+	if size, err = valueSize(typ); err != nil {
+		return "", err
+	}
+	if expr, err = bi.locationExpression(fv, sf.fn, sf.lookupPc()); err != nil {
+		return "", err
+	}
+	if pieces, err = locationPieces(pid, expr, sf); err != nil {
+		return "", err
+	}
+	if val, err = readPieces(pid, pieces, sf, size); err != nil {
+		return "", err
+	}
+	return formatValue(pid, val, typ)
+}
+
+// formatValue formats the value val of typ, just support base types, pointers and string for now.
+func formatValue(pid int, val []byte, typ dwarf.Type) (string, error) {
+	var err error
+	if t, ok := typ.(*dwarf.TypedefType); ok {
+		return formatValue(pid, val, t.Type)
+	}
+	size := len(val)
+
+	switch t := typ.(type) {
+	case *dwarf.StructType:
+		if t.StructName != "string" {
+			break
+		}
+		addr := binary.LittleEndian.Uint64(val[:8])
+		strlen := int64(binary.LittleEndian.Uint64(val[8:]))
+		if strlen < 0 || strlen > maxStringLen {
+			return "", fmt.Errorf("invalid string length %d", strlen)
+		}
+		if strlen == 0 {
+			return "", nil
+		}
+		if addr == 0 {
+			return "", fmt.Errorf("pointer addr %d shoulde be == 0", addr)
+		}
+		logger.Debug(fmt.Sprintf("len = %d, addr = %d\n", strlen, addr))
+		strpointer := make([]byte, strlen)
+		if _, err = readMemory(pid, addr, strpointer); err != nil {
+			return "", err
+		}
+		return string(strpointer), nil
+	case *dwarf.BoolType:
+		return fmt.Sprintf("%v", val[0] != 0), nil
+	case *dwarf.IntType:
+		return fmt.Sprintf("%d", signExtend(val)), nil
+	case *dwarf.UintType, *dwarf.UcharType:
+		return fmt.Sprintf("%d", readUnsigned(val)), nil
+	case *dwarf.CharType:
+		return fmt.Sprintf("%d", signExtend(val)), nil
+	case *dwarf.FloatType:
+		if size == 4 {
+			return fmt.Sprintf("%v", math.Float32frombits(uint32(readUnsigned(val)))), nil
+		}
+		return fmt.Sprintf("%v", math.Float64frombits(readUnsigned(val))), nil
+	case *dwarf.PtrType:
+		return fmt.Sprintf("0x%x", readUnsigned(val)), nil
+	}
+	return "", fmt.Errorf("not support type %s", typ.String())
+}
+
+func readUnsigned(val []byte) uint64 {
+	var res uint64
+	for i := len(val) - 1; i >= 0 && i < 8; i-- {
+		res = res<<8 | uint64(val[i])
+	}
+	return res
+}
+
+func signExtend(val []byte) int64 {
+	res := readUnsigned(val)
+	if bits := uint(len(val) * 8); bits < 64 {
+		shift := 64 - bits
+		return int64(res<<shift) >> shift
+	}
+	return int64(res)
+}
+
+// frameVariables returns `name = value` of the variables in the frame whose tag is tag.
+func (bi *BI) frameVariables(pid int, sf *Stackframe, tag dwarf.Tag) []string {
+	res := make([]string, 0)
+	if sf.fn == nil {
+		return res
+	}
+	for _, fv := range sf.fn.variables {
+		if fv.Tag != tag {
+			continue
+		}
+		val, err := bi.readVariable(pid, fv, sf)
+		if err != nil {
+			val = fmt.Sprintf("<%s>", err.Error())
+		}
+		res = append(res, fmt.Sprintf("%s = %s", variableName(fv), val))
+	}
+	return res
+}
+
+// printVariables prints the variables of the selected frame whose tag is tag, like `locals` and `args`.
+func printVariables(bi *BI, bp *BP, pid int, tag dwarf.Tag) error {
+	sf, err := bi.selectedFrame(bp, pid)
+	if err != nil {
+		return err
+	}
+	if sf.fn == nil {
+		return &NotFoundFuncErr{pc: sf.lookupPc()}
+	}
+	vars := bi.frameVariables(pid, sf, tag)
+	for _, v := range vars {
+		fmt.Fprintf(stdout, "%s\n", v)
+	}
+	if len(vars) == 0 {
+		if tag == dwarf.TagFormalParameter {
+			fmt.Fprintf(stdout, "no arguments\n")
+		} else {
+			fmt.Fprintf(stdout, "no locals\n")
+		}
+	}
+	return nil
+}